@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/arclabs561/mdpreview/server"
+)
+
+// check prints the problems s finds with the file at path, one per line as
+// path:line: kind: message, followed by a count. It returns the exit code:
+// 1 if there were errors, or warnings with strict set, and 0 otherwise.
+func check(s *server.Server, path string, strict bool) int {
+	problems, err := s.Check()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	errors, warnings := 0, 0
+	for _, p := range problems {
+		if p.Line == 0 {
+			fmt.Printf("%s: %s\n", path, p)
+		} else {
+			fmt.Printf("%s:%s\n", path, p)
+		}
+		if p.Warning {
+			warnings++
+		} else {
+			errors++
+		}
+	}
+	fmt.Printf("%d errors, %d warnings\n", errors, warnings)
+	if errors > 0 || (strict && warnings > 0) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfig is read from the current directory when -config isn't given.
+const defaultConfig = ".mdpreview.yml"
+
+// loadConfig applies the config file at path to the flags not set on the
+// command line. Keys are flag names, such as theme or api, and lists are
+// joined with commas, or given item by item to repeatable flags. The file is
+// TOML if it ends in .toml and YAML otherwise. With path empty,
+// defaultConfig is read if it exists.
+func loadConfig(path string, log *logrus.Logger) error {
+	optional := path == ""
+	if optional {
+		path = defaultConfig
+	}
+	data, err := os.ReadFile(path)
+	if optional && errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, &values)
+	} else {
+		err = yaml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, value := range values {
+		if flag.Lookup(name) == nil || name == "config" {
+			log.Warnf("%s: unknown option %q", path, name)
+			continue
+		}
+		if set[name] {
+			continue
+		}
+		// Repeatable flags take list items one at a time.
+		list, isList := value.([]interface{})
+		if _, repeated := flag.Lookup(name).Value.(*repeatedFlag); repeated && isList {
+			for _, item := range list {
+				if err := flag.Set(name, fmt.Sprint(item)); err != nil {
+					return fmt.Errorf("%s: %s: %v", path, name, err)
+				}
+			}
+			continue
+		}
+		if err := flag.Set(name, configValue(value)); err != nil {
+			return fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+	}
+	log.WithField("config", path).Debug("loaded config")
+	return nil
+}
+
+// configValue formats a config value as it would be given on the command
+// line.
+func configValue(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(value)
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -20,49 +24,262 @@ import (
 )
 
 var (
-	addr  = flag.String("addr", ":8080", "address to serve preview like :8080 or 0.0.0.0:7000")
-	api   = flag.Bool("api", false, "whether to render via the Github API")
-	debug = flag.Bool("debug", false, "debug logging")
+	addr        = flag.String("addr", ":8080", "address to serve preview like :8080 or 0.0.0.0:7000 (with -allow-remote)")
+	allowRemote = flag.Bool("allow-remote", false, "allow an -addr reachable from other machines, such as 0.0.0.0:7000")
+	api         = flag.Bool("api", false, "whether to render via the Github API")
+	engine      = flag.String("engine", server.EngineGFM, "Markdown engine for local renders: gfm, or goldmark for CommonMark with footnotes and definition lists")
+	token       = flag.String("token", "", "GitHub token for -api renders, to avoid rate limiting (default $GITHUB_TOKEN)")
+	debug       = flag.Bool("debug", false, "debug logging")
+	showVersion = flag.Bool("version", false, "print the version and exit")
+	configFile  = flag.String("config", "", "YAML or TOML file of flag values; command-line flags override it (default "+defaultConfig+" if present)")
+	open        = flag.Bool("open", false, "open the preview in the default browser once the server is up")
+	autoPort    = flag.Bool("auto-port", false, "if the -addr port is taken, listen on a free one instead")
+	route       = flag.String("route", "/", "path to serve the preview page at, e.g. /preview")
+	cert        = flag.String("cert", "", "TLS certificate file; serves HTTPS together with -key")
+	key         = flag.String("key", "", "TLS private key file; serves HTTPS together with -cert")
+	shareKey    = flag.String("share-key", "", "secret for signing read-only share links; other hosts then need one")
+	shareTTL    = flag.Duration("share-ttl", 24*time.Hour, "how long the share link logged at startup stays valid")
+	shareFile   = flag.String("share-file", "", "document the share link opens, relative to the directory or as given when previewing several files")
+	shareHost   = flag.String("share-host", "", "host name or address for the share link (default this machine's when serving on all interfaces)")
+	auth        = flag.String("auth", "", "require HTTP basic auth as user:pass")
+	reloadGroup = flag.String("reload-group", "", "re-render whenever a file previewed by another instance in this named group changes")
+	allowSave   = flag.Bool("allow-save", false, "let the browser write the file with save messages over the WebSocket")
+	dev         = flag.Bool("dev", false, "serve static files from server/static in the current directory, uncached, for working on mdpreview")
+	watchExtra  repeatedFlag
+
+	renderOnce = flag.Bool("render", false, "write the file rendered as a standalone HTML page to stdout and exit, without serving")
+	pdfOut     = flag.String("pdf", "", "write the file rendered for print to this PDF and exit, without serving; needs Chrome, Chromium or wkhtmltopdf")
+
+	diagnostics      = flag.Bool("diagnostics", false, "show a live graph of render durations in the preview")
+	logStats         = flag.Bool("stats", false, "log how long each update takes to read, render and send to the browser")
+	highlightChanges = flag.Bool("highlight-changes", false, "briefly highlight the parts of the preview that changed with each update")
+	contentOnly      = flag.Bool("content-only", false, "ignore file events, such as touch or chmod, that don't change the content")
+	comments         = flag.Bool("comments", false, "enable review comments, Alt+click a block to comment; stored next to the file as FILE.comments.json")
+	clientDebug      = flag.Bool("client-debug", false, "log connection and message events to the browser console")
+
+	mdExtensions  = flag.String("md-extensions", ".md,.markdown,.mdown,.mkd,.mdx", "comma separated extensions recognized as Markdown, for the startup check")
+	noExtCheck    = flag.Bool("no-ext-check", false, "don't warn when the file's extension isn't a recognized Markdown one")
+	format        = flag.String("format", server.FormatAuto, "file format: markdown, html, or auto to pick by extension")
+	sanitizeHTML  = flag.Bool("sanitize-html", false, "strip scripts and other unsafe markup when previewing HTML files")
+	autolink      = flag.String("autolink", server.AutolinkOn, "link bare URLs: on, off, or www to also link www. addresses")
+	validateCode  = flag.Bool("validate-code", false, "mark JSON, YAML and TOML code blocks that fail to parse")
+	noFrontMatter = flag.Bool("no-frontmatter", false, "render YAML front matter as Markdown instead of a metadata table")
+	cover         = flag.Bool("cover", false, "show the title, subtitle, author and date from front matter as a title block")
+	templateFile  = flag.String("template", "", "HTML template for the preview page instead of the built-in one; see server/static/index.html")
+	customCSS     = flag.String("css", "", "stylesheet to apply after the built-in GitHub styles")
+	noMath        = flag.Bool("no-math", false, "leave $ and $$ to the Markdown renderer instead of rendering math with KaTeX")
+	smart         = flag.Bool("smart", false, "curly quotes, en and em dashes for -- and ---, and ellipses for ... outside of code")
+	emojiStyle    = flag.String("emoji-style", server.EmojiUnicode, "show :shortcode: emoji as unicode or twemoji images")
+	noEmoji       = flag.Bool("no-emoji", false, "leave :shortcode: emoji as written instead of showing them as emoji")
+	a11y          = flag.Bool("a11y", false, "mark images without alt text and headings that skip a level")
+	strict        = flag.Bool("strict", false, "with -render or check, exit non-zero on accessibility warnings; implies -a11y")
+	codeTheme     = flag.String("code-theme", server.DefaultCodeTheme, "Chroma style for highlighting code blocks when rendering locally, e.g. github or monokai")
+	theme         = flag.String("theme", server.ThemeAuto, "color theme: light, dark, or auto to follow the browser (or the OS for static output)")
+
+	allowOrigin        = flag.String("allow-origin", "", "comma separated origins allowed to embed the preview widget, or * for any")
+	delayInitialRender = flag.Duration("delay-initial-render", 0, "wait this long before the first render, e.g. 500ms, for files still being written at startup")
+	readRetries        = flag.Int("read-retries", 3, "times to retry a failed read of the file before reporting an error")
+	readBackoff        = flag.Duration("read-backoff", 50*time.Millisecond, "wait before the first read retry, doubling after each attempt")
+	debounce           = flag.Duration("debounce", 150*time.Millisecond, "wait for the file to be quiet this long after a change before rendering, 0 to render on every event")
+	pingInterval       = flag.Duration("ping-interval", server.DefaultPingInterval, "how often to ping the browser to keep the connection alive")
+	wsTimeout          = flag.Duration("ws-timeout", server.DefaultWSTimeout, "drop a browser that doesn't answer pings for this long; at least twice -ping-interval")
+	writeTimeout       = flag.Duration("write-timeout", server.DefaultWriteTimeout, "drop a browser that takes longer than this to receive a message")
+	printRendered      = flag.Bool("print-rendered", false, "print the rendered HTML to stdout on every render, truncated")
+	printRenderedFull  = flag.Bool("print-rendered-full", false, "like -print-rendered, without truncation")
+	shutdownTimeout    = flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for open requests to finish when shutting down")
+	wsBinary           = flag.Bool("ws-binary", false, "send renders as gzip-compressed binary WebSocket messages")
 )
 
+// printRenderedLimit is how much of each render -print-rendered shows.
+const printRenderedLimit = 4096
+
 func main() {
+	flag.Var(&watchExtra, "watch-extra", "also re-render when this file changes, such as an include or stylesheet; may be repeated")
 	flag.Parse()
+	// "mdpreview check FILE" checks FILE instead of serving it. Flags may
+	// come before or after the command and the file.
+	args := flag.Args()
+	checking := flag.Arg(0) == "check"
+	if checking {
+		// The flag set exits on errors.
+		args, _ = parseInterspersed(flag.CommandLine, args[1:])
+	}
+
+	if *showVersion {
+		v, c := buildVersion()
+		fmt.Println("mdpreview", v, c)
+		return
+	}
 
 	log := logrus.New()
+	if err := loadConfig(*configFile, log); err != nil {
+		log.Fatal(err)
+	}
 	if *debug {
 		log.SetLevel(logrus.DebugLevel)
 	}
 
-	// Fix: Use flag.Args() instead of os.Args after flag.Parse()
-	args := flag.Args()
 	if len(args) < 1 {
-		log.Fatal("markdown file path must be provided as an argument")
+		log.Fatal("markdown file or directory path must be provided as an argument")
 	}
 	path := args[0]
+	if len(args) > 1 && (checking || *renderOnce || *pdfOut != "") {
+		log.Fatal("check, -render and -pdf take a single file")
+	}
 
-	if filepath.Ext(path) != ".md" {
-		log.Warnf("path %s doesn't look like a Markdown file", path)
+	if (*cert == "") != (*key == "") {
+		log.Fatal("-cert and -key must be given together")
 	}
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		log.Fatalf("path %s does not exist", path)
+	scheme := "http"
+	if *cert != "" {
+		scheme = "https"
+	}
+
+	// Several files are previewed side by side.
+	isDir := false
+	for _, p := range args {
+		info, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			log.Fatalf("path %s does not exist", p)
+		}
+		isDir = isDir || err == nil && info.IsDir()
+		if (err != nil || !info.IsDir()) && extensionWarning(p, splitList(*mdExtensions), *format, *noExtCheck) {
+			log.Warnf("path %s doesn't look like a Markdown file", p)
+		}
+	}
+	if isDir && checking {
+		log.Fatal("check needs a file, not a directory")
+	}
+	if isDir && *renderOnce {
+		log.Fatal("-render needs a file, not a directory")
+	}
+	if isDir && *pdfOut != "" {
+		log.Fatal("-pdf needs a file, not a directory")
 	}
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	s, err := server.New(ctx, path, log, !*api)
-	if err != nil {
-		log.Fatal(err)
+	buildVer, buildCommit := buildVersion()
+	opts := server.Options{
+		RenderLocally:      !*api,
+		Engine:             *engine,
+		InitialRenderDelay: *delayInitialRender,
+		AllowedOrigins:     splitList(*allowOrigin),
+		ReadRetries:        *readRetries,
+		ReadBackoff:        *readBackoff,
+		Debounce:           *debounce,
+		PingInterval:       *pingInterval,
+		WSTimeout:          *wsTimeout,
+		WriteTimeout:       *writeTimeout,
+		Theme:              *theme,
+		BinaryMessages:     *wsBinary,
+		Format:             *format,
+		SanitizeHTML:       *sanitizeHTML,
+		Autolink:           *autolink,
+		GitHubToken:        *token,
+		ClientDebug:        *clientDebug,
+		ValidateCode:       *validateCode,
+		NoFrontMatter:      *noFrontMatter,
+		Cover:              *cover,
+		Template:           *templateFile,
+		CSS:                *customCSS,
+		NoMath:             *noMath,
+		Smart:              *smart,
+		EmojiStyle:         *emojiStyle,
+		NoEmoji:            *noEmoji,
+		A11y:               *a11y,
+		Strict:             *strict,
+		CodeTheme:          *codeTheme,
+		Comments:           *comments,
+		ContentOnly:        *contentOnly,
+		Diagnostics:        *diagnostics,
+		LogStats:           *logStats,
+		HighlightChanges:   *highlightChanges,
+		Route:              *route,
+		ShareKey:           []byte(*shareKey),
+		BasicAuth:          *auth,
+		ReloadGroup:        *reloadGroup,
+		AllowSave:          *allowSave,
+		WatchExtra:         watchExtra,
+		Version:            buildVer,
+		Commit:             buildCommit,
 	}
-	h, err := s.Run()
+	if *dev {
+		opts.StaticDir = filepath.Join("server", "static")
+		if _, err := os.Stat(opts.StaticDir); err != nil {
+			log.Fatalf("-dev must be run from the mdpreview repository: %v", err)
+		}
+	}
+	if opts.GitHubToken == "" {
+		opts.GitHubToken = os.Getenv("GITHUB_TOKEN")
+	}
+	if checking {
+		// Run every check, reporting rather than failing on warnings.
+		opts.ValidateCode = true
+		opts.A11y = true
+		opts.Strict = false
+	}
+	// Renders are the output of -render and -pdf, so don't also print them.
+	if (*printRendered || *printRenderedFull) && !*renderOnce && *pdfOut == "" && !checking {
+		opts.RenderOutput = os.Stdout
+		if !*printRenderedFull {
+			opts.RenderOutputLimit = printRenderedLimit
+		}
+	}
+
+	s, err := server.New(ctx, args, log, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if checking {
+		os.Exit(check(s, path, *strict))
+	}
+	var shareQuery string
+	if *shareKey != "" && !*renderOnce && *pdfOut == "" {
+		if shareQuery, err = s.ShareQuery(*shareFile, *shareTTL); err != nil {
+			log.Fatalf("%v; name the document to share with -share-file", err)
+		}
+	}
+	if *renderOnce {
+		rendered, err := s.Render()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := s.WritePage(os.Stdout, rendered); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *pdfOut != "" {
+		if err := writePDF(ctx, s, path, *pdfOut); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	if strings.HasPrefix(*addr, ":") {
 		*addr = fmt.Sprintf("127.0.0.1%s", *addr)
 	}
+	if !loopbackAddr(*addr) {
+		if !*allowRemote {
+			log.Fatalf("%s is reachable from other machines; pass -allow-remote to serve on it anyway", *addr)
+		}
+		switch {
+		case *auth != "" || *shareKey != "":
+			log.Warnf("Serving on %s, reachable from other machines with -auth credentials or a share link", *addr)
+		case *allowSave:
+			log.Warnf("Serving on %s, reachable from other machines: anyone who can connect can read and, with -allow-save, overwrite the previewed files", *addr)
+		default:
+			log.Warnf("Serving on %s, reachable from other machines: anyone who can connect can read the previewed files", *addr)
+		}
+	}
+
+	h, err := s.Run()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Setup HTTP server with timeouts
 	srv := &http.Server{
@@ -73,14 +290,43 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Listen up front so that the address logged and opened is the one
+	// actually bound, e.g. with port 0 or -auto-port.
+	ln, err := listen(*addr, *autoPort, log)
+	if err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+	if host, _, err := net.SplitHostPort(*addr); err == nil {
+		*addr = net.JoinHostPort(host, strconv.Itoa(ln.Addr().(*net.TCPAddr).Port))
+	}
+
+	if shareQuery != "" {
+		log.Infof("Read-only share link, valid for %s: %s://%s%s?%s", *shareTTL, scheme, shareAddr(*addr, *shareHost), *route, shareQuery)
+	}
+
 	// Start server in goroutine
 	go func() {
-		log.Infof("Starting mdpreview server at http://%s", *addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Infof("Starting mdpreview server at %s://%s", scheme, *addr)
+		var err error
+		if *cert != "" {
+			err = srv.ServeTLS(ln, *cert, *key)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
 
+	// The listener is already bound, so the browser's request just waits to
+	// be accepted.
+	if *open {
+		url := fmt.Sprintf("%s://%s%s", scheme, *addr, *route)
+		if err := openBrowser(url); err != nil {
+			log.WithError(err).Warnf("failed to open %s in a browser", url)
+		}
+	}
+
 	// Wait for interrupt signal for graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -90,16 +336,103 @@ func main() {
 	cancel() // Cancel context to signal goroutines
 
 	// Graceful shutdown with timeout
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), *shutdownTimeout)
 	defer shutdownCancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		log.Errorf("Server forced to shutdown: %v", err)
 	}
+	if err := s.Wait(shutdownCtx); err != nil {
+		log.Errorf("Timed out waiting for connections to close: %v", err)
+	}
 
 	log.Info("Server stopped")
 }
 
+// loopbackAddr reports whether the host of addr, an IPv4 or IPv6 literal or
+// a name, only reaches this machine. Names count if they resolve only to
+// loopback addresses.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip.IsLoopback()
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return false
+		}
+	}
+	return true
+}
+
+// shareAddr returns the address to give other machines for a server
+// listening on addr: with host instead of addr's host if set, or with this
+// machine's address when addr is on all interfaces, such as 0.0.0.0:8080.
+func shareAddr(addr, host string) string {
+	h, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host != "" {
+		return net.JoinHostPort(host, port)
+	}
+	if ip := net.ParseIP(h); ip == nil || !ip.IsUnspecified() {
+		return addr
+	}
+	if ip := externalIP(); ip != "" {
+		return net.JoinHostPort(ip, port)
+	}
+	if name, err := os.Hostname(); err == nil {
+		return net.JoinHostPort(name, port)
+	}
+	return addr
+}
+
+// externalIP returns an address of this machine that other machines can
+// reach, preferring IPv4, or "" if it has none.
+func externalIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	var v6 string
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP.String()
+		}
+		if v6 == "" {
+			v6 = ipnet.IP.String()
+		}
+	}
+	return v6
+}
+
+// listen listens on addr. With auto set, a port already in use is swapped
+// for a free one picked by the OS.
+func listen(addr string, auto bool, log *logrus.Logger) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err == nil || !auto || !errors.Is(err, syscall.EADDRINUSE) {
+		return ln, err
+	}
+	host, _, splitErr := net.SplitHostPort(addr)
+	if splitErr != nil {
+		return nil, err
+	}
+	log.WithError(err).Warn("port in use, picking a free one")
+	return net.Listen("tcp", net.JoinHostPort(host, "0"))
+}
+
 func createHandler(h http.Handler, log *logrus.Logger) http.Handler {
 	n := negroni.New()
 	n.Use(negroni.NewRecovery())
@@ -107,3 +440,69 @@ func createHandler(h http.Handler, log *logrus.Logger) http.Handler {
 	n.UseHandler(h)
 	return n
 }
+
+// parseInterspersed parses the flags in args with fs, allowing them after
+// positional arguments too, up to a "--", and returns the positional
+// arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// extensionWarning reports whether to warn that the file at path doesn't
+// look like Markdown, as its extension isn't one of exts. Nothing is checked
+// with noCheck or a format given explicitly, and HTML files are fine.
+func extensionWarning(path string, exts []string, format string, noCheck bool) bool {
+	if noCheck || format != server.FormatAuto || server.DetectFormat(path) == server.FormatHTML {
+		return false
+	}
+	return !hasExtension(path, exts)
+}
+
+// hasExtension reports whether path ends in one of exts, ignoring case. The
+// leading dot of an extension is optional.
+func hasExtension(path string, exts []string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	for _, e := range exts {
+		if strings.EqualFold(ext, strings.TrimPrefix(e, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// repeatedFlag collects the values of a flag given more than once.
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *repeatedFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/arclabs561/mdpreview/server"
+	"github.com/sirupsen/logrus"
+)
+
+func TestExtensionWarning(t *testing.T) {
+	exts := splitList(".md,.markdown, mdx,,")
+	tests := []struct {
+		path    string
+		format  string
+		noCheck bool
+		warn    bool
+	}{
+		{"README.md", server.FormatAuto, false, false},
+		{"notes.MARKDOWN", server.FormatAuto, false, false},
+		{"page.mdx", server.FormatAuto, false, false},
+		{"page.html", server.FormatAuto, false, false},
+		{"notes.txt", server.FormatAuto, false, true},
+		{"Makefile", server.FormatAuto, false, true},
+		{"notes.txt", server.FormatAuto, true, false},
+		{"notes.txt", server.FormatMarkdown, false, false},
+	}
+	for _, tt := range tests {
+		if got := extensionWarning(tt.path, exts, tt.format, tt.noCheck); got != tt.warn {
+			t.Errorf("extensionWarning(%q, format %s, no check %v) = %v, want %v", tt.path, tt.format, tt.noCheck, got, tt.warn)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	if got, want := splitList(" .md, .txt ,,"), []string{".md", ".txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("splitList = %q, want %q", got, want)
+	}
+	if got := splitList(""); got != nil {
+		t.Errorf("splitList of nothing = %q", got)
+	}
+}
+
+// newCheckServer returns a server checking a file holding content, set up
+// the way the check command sets it up.
+func newCheckServer(t *testing.T, content string) (*server.Server, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "doc.md")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Documents may show shot.png.
+	if err := os.WriteFile(filepath.Join(filepath.Dir(path), "shot.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	s, err := server.New(ctx, []string{path}, log, server.Options{RenderLocally: true, ValidateCode: true, A11y: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name, content string
+		want          int
+	}{
+		{"clean", "---\ntitle: Doc\n---\n# Title\n\nSee [below](#title) and ![Shot](shot.png).\n", 0},
+		{"missing file", "# Title\n\nSee [notes](notes.md).\n", 1},
+		{"missing heading", "# Title\n\nSee [below](#nowhere).\n", 1},
+		{"missing image", "# Title\n\n![Plot](plot.png)\n", 1},
+		{"invalid front matter", "---\ntitle: [unclosed\n---\n# Title\n", 1},
+		{"invalid JSON", "# Title\n\n```json\n{,}\n```\n", 1},
+	}
+	for _, tt := range tests {
+		s, path := newCheckServer(t, tt.content)
+		if code := check(s, path, false); code != tt.want {
+			t.Errorf("%s: check exited %d, want %d", tt.name, code, tt.want)
+		}
+	}
+
+	s, path := newCheckServer(t, "# Title\n")
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if code := check(s, path, false); code != 1 {
+		t.Errorf("check of a removed file exited %d, want 1", code)
+	}
+}
+
+func TestCheckStrict(t *testing.T) {
+	s, path := newCheckServer(t, "# Title\n\n### Skipped\n\n![](shot.png)\n")
+	if code := check(s, path, false); code != 0 {
+		t.Errorf("check with accessibility warnings exited %d, want 0", code)
+	}
+	if code := check(s, path, true); code != 1 {
+		t.Errorf("strict check with accessibility warnings exited %d, want 1", code)
+	}
+
+	s, path = newCheckServer(t, "# Title\n\n## Section\n\n![Shot](shot.png)\n")
+	if code := check(s, path, true); code != 0 {
+		t.Errorf("strict check of an accessible document exited %d, want 0", code)
+	}
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	for _, tt := range []struct {
+		addr     string
+		loopback bool
+	}{
+		{"127.0.0.1:0", true},
+		{"127.0.0.2:0", true},
+		{"[::1]:0", true},
+		{"[::ffff:127.0.0.1]:0", true},
+		{"localhost:0", true},
+		{"0.0.0.0:0", false},
+		{"[::]:0", false},
+		{":0", false},
+		{"192.168.1.10:0", false},
+		{"[fe80::1]:0", false},
+		{"127.0.0.1", false},
+	} {
+		if got := loopbackAddr(tt.addr); got != tt.loopback {
+			t.Errorf("loopbackAddr(%q) = %v, want %v", tt.addr, got, tt.loopback)
+		}
+	}
+}
+
+func TestShareAddr(t *testing.T) {
+	for _, tt := range []struct{ addr, host, want string }{
+		{"127.0.0.1:8080", "", "127.0.0.1:8080"},
+		{"192.0.2.7:8080", "", "192.0.2.7:8080"},
+		{"0.0.0.0:8080", "docs.example", "docs.example:8080"},
+		{"[::]:8080", "192.0.2.7", "192.0.2.7:8080"},
+	} {
+		if got := shareAddr(tt.addr, tt.host); got != tt.want {
+			t.Errorf("shareAddr(%q, %q) = %q, want %q", tt.addr, tt.host, got, tt.want)
+		}
+	}
+	// All interfaces are swapped for an address others can reach.
+	for _, addr := range []string{"0.0.0.0:8080", "[::]:8080"} {
+		host, port, err := net.SplitHostPort(shareAddr(addr, ""))
+		if err != nil || port != "8080" {
+			t.Fatalf("shareAddr(%q) = %s:%s, %v", addr, host, port, err)
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			t.Errorf("shareAddr(%q) kept the unspecified address", addr)
+		}
+	}
+}
+
+func TestParseInterspersed(t *testing.T) {
+	tests := []struct {
+		args, want []string
+		strict     bool
+	}{
+		{[]string{"doc.md"}, []string{"doc.md"}, false},
+		{[]string{"-strict", "doc.md"}, []string{"doc.md"}, true},
+		{[]string{"doc.md", "-strict"}, []string{"doc.md"}, true},
+		{[]string{"a.md", "-strict", "b.md"}, []string{"a.md", "b.md"}, true},
+		{[]string{"doc.md", "--", "-strict"}, []string{"doc.md", "-strict"}, false},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		strict := fs.Bool("strict", false, "")
+		got, err := parseInterspersed(fs, tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) || *strict != tt.strict {
+			t.Errorf("parseInterspersed(%q) = %q, strict %v, want %q, strict %v", tt.args, got, *strict, tt.want, tt.strict)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if _, err := parseInterspersed(fs, []string{"doc.md", "-unknown"}); err == nil {
+		t.Error("unknown flag after the file accepted")
+	}
+}
+
+func TestRepeatedFlag(t *testing.T) {
+	var f repeatedFlag
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&f, "watch-extra", "")
+	if err := fs.Parse([]string{"-watch-extra", "a.md", "-watch-extra", "b,c.css"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (repeatedFlag{"a.md", "b,c.css"}); !reflect.DeepEqual(f, want) {
+		t.Errorf("values = %q, want %q", f, want)
+	}
+	if got := f.String(); got != "a.md,b,c.css" {
+		t.Errorf("String() = %q", got)
+	}
+}
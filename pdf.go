@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/arclabs561/mdpreview/server"
+)
+
+// pdfBrowsers are the headless browsers tried, in order, to print a PDF.
+var pdfBrowsers = []string{
+	"chromium",
+	"chromium-browser",
+	"google-chrome",
+	"google-chrome-stable",
+	"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+}
+
+// writePDF renders the file s serves to a PDF at out. The print page is
+// written next to the file for the browser to load, so that relative images
+// resolve, and removed afterwards.
+func writePDF(ctx context.Context, s *server.Server, path, out string) error {
+	rendered, err := s.Render()
+	if err != nil {
+		return err
+	}
+	page, err := os.CreateTemp(filepath.Dir(path), ".mdpreview-*.html")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(page.Name())
+	err = s.WritePrintPage(page, rendered)
+	if closeErr := page.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	in, err := filepath.Abs(page.Name())
+	if err != nil {
+		return err
+	}
+	out, err = filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	return printPDF(ctx, in, out)
+}
+
+// printPDF prints the HTML page at in to a PDF at out with a headless Chrome
+// or Chromium, or wkhtmltopdf if neither is installed. Both paths must be
+// absolute.
+func printPDF(ctx context.Context, in, out string) error {
+	for _, name := range pdfBrowsers {
+		if bin, err := exec.LookPath(name); err == nil {
+			page := &url.URL{Scheme: "file", Path: filepath.ToSlash(in)}
+			// The time budget lets math and diagram scripts finish first.
+			return runPDF(ctx, bin, "--headless", "--disable-gpu", "--no-pdf-header-footer",
+				"--virtual-time-budget=10000", "--print-to-pdf="+out, page.String())
+		}
+	}
+	if bin, err := exec.LookPath("wkhtmltopdf"); err == nil {
+		return runPDF(ctx, bin, "--quiet", "--enable-local-file-access", "--javascript-delay", "2000", in, out)
+	}
+	return errors.New("-pdf needs Chrome, Chromium or wkhtmltopdf installed")
+}
+
+func runPDF(ctx context.Context, bin string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v: %s", filepath.Base(bin), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// a11yIssue is an accessibility problem found in a render, at the source
+// line of the block containing it. Line is 0 when unknown, as for HTML files.
+type a11yIssue struct {
+	Line    int
+	Message string
+}
+
+func (i a11yIssue) String() string {
+	if i.Line == 0 {
+		return i.Message
+	}
+	return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+}
+
+// a11yError fails a strict render that has accessibility issues.
+type a11yError struct {
+	issues []a11yIssue
+}
+
+func (e *a11yError) Error() string {
+	lines := make([]string, len(e.issues))
+	for i, issue := range e.issues {
+		lines[i] = issue.String()
+	}
+	return fmt.Sprintf("accessibility warnings: %s", strings.Join(lines, "; "))
+}
+
+// checkAccessibility returns a transform that finds images without alt text
+// and headings that skip a level, such as an h3 straight after an h1. Each
+// issue is marked with a warning before its top-level block and appended to
+// issues.
+func checkAccessibility(issues *[]a11yIssue) transform {
+	return func(root *html.Node) {
+		type found struct {
+			block *html.Node
+			issue a11yIssue
+		}
+		var all []found
+		level := 0
+		for block := root.FirstChild; block != nil; block = block.NextSibling {
+			if block.Type != html.ElementNode {
+				continue
+			}
+			line := 0
+			if v, ok := attr(block, "data-source-line"); ok {
+				line, _ = strconv.Atoi(v)
+			}
+			walk(block, func(n *html.Node) bool {
+				if n.Type != html.ElementNode {
+					return true
+				}
+				if n.Data == "img" {
+					if alt, _ := attr(n, "alt"); strings.TrimSpace(alt) == "" {
+						all = append(all, found{block, a11yIssue{line, "image without alt text"}})
+					}
+				}
+				if l := headingLevel(n); l > 0 {
+					if level > 0 && l > level+1 {
+						msg := fmt.Sprintf("heading level skipped: h%d follows h%d", l, level)
+						all = append(all, found{block, a11yIssue{line, msg}})
+					}
+					level = l
+				}
+				return true
+			})
+		}
+		for _, f := range all {
+			f.block.Parent.InsertBefore(warningNode("a11y-warning", "Accessibility: "+f.issue.Message), f.block)
+			*issues = append(*issues, f.issue)
+		}
+	}
+}
+
+// headingLevel returns the level of an h1 to h6 element, or 0.
+func headingLevel(n *html.Node) int {
+	if len(n.Data) == 2 && n.Data[0] == 'h' && n.Data[1] >= '1' && n.Data[1] <= '6' {
+		return int(n.Data[1] - '0')
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckAccessibility(t *testing.T) {
+	const src = "# Title\n\n" +
+		"![](shot.png)\n\n" +
+		"### Skipped\n\n" +
+		"![Logo](logo.png) and ![ ](blank.png)\n\n" +
+		"## Back up\n\n" +
+		"### Down one\n"
+	want := []a11yIssue{
+		{3, "image without alt text"},
+		{5, "heading level skipped: h3 follows h1"},
+		{7, "image without alt text"},
+	}
+	for _, engine := range []string{EngineGFM, EngineGoldmark} {
+		// Strict renders report the issues found.
+		s := newTestServer(t, "doc.md", src, Options{Engine: engine, Strict: true})
+		_, err := s.Render()
+		var a11y *a11yError
+		if !errors.As(err, &a11y) {
+			t.Fatalf("%s: error = %v, want accessibility issues", engine, err)
+		}
+		if !reflect.DeepEqual(a11y.issues, want) {
+			t.Errorf("%s: issues =\n%v\nwant\n%v", engine, a11y.issues, want)
+		}
+
+		s = newTestServer(t, "doc.md", src, Options{Engine: engine, A11y: true})
+		rendered, err := s.Render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Count(string(rendered), `class="a11y-warning"`); got != len(want) {
+			t.Errorf("%s: %d warnings shown, want %d:\n%s", engine, got, len(want), rendered)
+		}
+	}
+}
+
+func TestAccessibilityOff(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Title\n\n### Skipped\n\n![](shot.png)\n", Options{})
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(rendered), "a11y-warning") {
+		t.Errorf("warnings shown without -a11y:\n%s", rendered)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Title\n\n![](shot.png)\n", Options{Strict: true})
+	_, err := s.Render()
+	var a11y *a11yError
+	if !errors.As(err, &a11y) {
+		t.Fatalf("strict render error = %v, want the accessibility issues", err)
+	}
+	if !strings.Contains(err.Error(), "line 3: image without alt text") {
+		t.Errorf("error %q doesn't say what and where", err)
+	}
+
+	// The live preview shows the warnings rather than failing.
+	ws := dialTestServer(t, startTestServer(t, s), "")
+	if msg := readConnected(t, ws); !strings.Contains(msg.HTML, "a11y-warning") {
+		t.Errorf("live strict render lacks the warning:\n%s", msg.HTML)
+	}
+
+	clean := newTestServer(t, "doc.md", "# Title\n\n![Shot](shot.png)\n", Options{Strict: true})
+	if _, err := clean.Render(); err != nil {
+		t.Errorf("strict render of an accessible document: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// linkIconPath is the outline of GitHub's link octicon.
+const linkIconPath = "M7.775 3.275a.75.75 0 0 0 1.06 1.06l1.25-1.25a2 2 0 1 1 2.83 2.83l-2.5 2.5a2 2 0 0 1-2.83 0 .75.75 0 0 0-1.06 1.06 3.5 3.5 0 0 0 4.95 0l2.5-2.5a3.5 3.5 0 0 0-4.95-4.95l-1.25 1.25Zm-4.69 9.64a2 2 0 0 1 0-2.83l2.5-2.5a2 2 0 0 1 2.83 0 .75.75 0 0 0 1.06-1.06 3.5 3.5 0 0 0-4.95 0l-2.5 2.5a3.5 3.5 0 0 0 4.95 4.95l1.25-1.25a.75.75 0 0 0-1.06-1.06l-1.25 1.25a2 2 0 0 1-2.83 0Z"
+
+// headingAnchors gives every heading a GitHub style slug as its id, numbering
+// repeats, and a link to itself shown on hover. Whatever anchors the renderer
+// made are replaced, so both render paths link the same way.
+func headingAnchors(root *html.Node) {
+	var headings []*html.Node
+	used := map[string]bool{}
+	walk(root, func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return true
+		}
+		if headingLevel(n) > 0 {
+			if id, ok := attr(n, "id"); ok {
+				// Raw HTML headings keep their own ids.
+				used[id] = true
+			} else {
+				headings = append(headings, n)
+			}
+			return false
+		}
+		return true
+	})
+
+	for _, h := range headings {
+		removeAnchors(h)
+		slug := slugify(textContent(h))
+		id := slug
+		for i := 1; used[id]; i++ {
+			id = slug + "-" + strconv.Itoa(i)
+		}
+		used[id] = true
+		setAttr(h, "id", id)
+		h.InsertBefore(anchorLink(id), h.FirstChild)
+	}
+}
+
+// removeAnchors detaches the anchors a renderer put in or next to heading h:
+// blackfriday nests them in the heading, the GitHub API puts them beside it
+// in a div.markdown-heading.
+func removeAnchors(h *html.Node) {
+	scopes := []*html.Node{h}
+	if p := h.Parent; p != nil && p.DataAtom == atom.Div {
+		if class, _ := attr(p, "class"); strings.Contains(" "+class+" ", " markdown-heading ") {
+			scopes = append(scopes, p)
+		}
+	}
+	for _, scope := range scopes {
+		for c := scope.FirstChild; c != nil; {
+			next := c.NextSibling
+			if class, _ := attr(c, "class"); c.DataAtom == atom.A && strings.Contains(" "+class+" ", " anchor ") {
+				scope.RemoveChild(c)
+			}
+			c = next
+		}
+	}
+}
+
+// anchorLink is the hover link to the heading with the given id.
+func anchorLink(id string) *html.Node {
+	a := element("a", atom.A, "anchor")
+	a.Attr = append(a.Attr,
+		html.Attribute{Key: "href", Val: "#" + id},
+		html.Attribute{Key: "aria-hidden", Val: "true"},
+	)
+	svg := &html.Node{Type: html.ElementNode, Data: "svg", Namespace: "svg", Attr: []html.Attribute{
+		{Key: "class", Val: "octicon octicon-link"},
+		{Key: "viewBox", Val: "0 0 16 16"},
+		{Key: "width", Val: "16"},
+		{Key: "height", Val: "16"},
+	}}
+	svg.AppendChild(&html.Node{Type: html.ElementNode, Data: "path", Namespace: "svg", Attr: []html.Attribute{
+		{Key: "d", Val: linkIconPath},
+	}})
+	a.AppendChild(svg)
+	return a
+}
+
+// slugify turns heading text into an id the way GitHub does: lower case,
+// spaces to hyphens, and punctuation other than hyphens and underscores
+// dropped.
+func slugify(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(text)) {
+		switch {
+		case r == ' ':
+			b.WriteByte('-')
+		case r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
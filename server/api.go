@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// maxContentSize bounds the content clients may send, whether saved over
+// the WebSocket or posted to /render.
+const maxContentSize = 5 * 1024 * 1024
+
+// handleRender renders the request body, Markdown unless an HTML file is
+// being previewed, the way a standalone page would show it, and responds
+// with {"html":...}. No file is involved, so editors and scripts can use
+// the server to render anything. Pages on other origins may not, as the
+// render runs on this machine.
+func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
+	if !s.checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	input, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxContentSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "content too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to read content", http.StatusBadRequest)
+		return
+	}
+
+	rendered, _, err := s.renderInput("", input, renderRequest)
+	if err != nil {
+		s.log.WithError(err).Error("failed to render posted content")
+		http.Error(w, "Failed to render content", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		HTML string `json:"html"`
+	}{string(rendered)})
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// postRender posts body to the render route of the server at url, from
+// origin if not empty, returning the status and the HTML rendered.
+func postRender(t *testing.T, url, origin, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest("POST", url+"/render", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, ""
+	}
+	var rendered struct {
+		HTML string `json:"html"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rendered); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, rendered.HTML
+}
+
+func TestRenderAPI(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{})
+	ts := startTestServer(t, s)
+	status, html := postRender(t, ts.URL, "", "*posted*\n")
+	if status != http.StatusOK || !strings.Contains(html, "<em>posted</em>") {
+		t.Errorf("POST /render: status %d, %s", status, html)
+	}
+	if status, _ := postRender(t, ts.URL, "", strings.Repeat("x", maxContentSize+1)); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST /render past the size limit: status %d, want 413", status)
+	}
+}
+
+func TestRenderAPIOrigin(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{AllowedOrigins: []string{"https://app.example"}})
+	ts := startTestServer(t, s)
+	for origin, want := range map[string]int{
+		ts.URL:                 http.StatusOK,
+		"https://app.example":  http.StatusOK,
+		"https://evil.example": http.StatusForbidden,
+	} {
+		if status, _ := postRender(t, ts.URL, origin, "text\n"); status != want {
+			t.Errorf("POST /render from %s: status %d, want %d", origin, status, want)
+		}
+	}
+}
+
+func TestRenderAPIWithoutSideEffects(t *testing.T) {
+	fakeMermaidCLI(t)
+	var out lockedBuffer
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{Strict: true, RenderOutput: &out})
+	ts := startTestServer(t, s)
+	status, html := postRender(t, ts.URL, "", diagramDoc+"\n![](shot.png)\n")
+	if status != http.StatusOK {
+		t.Fatalf("POST /render with an accessibility issue in strict mode: status %d", status)
+	}
+	if strings.Contains(html, "<text>drawn</text>") || !strings.Contains(html, "graph TD") {
+		t.Errorf("posted diagram drawn with mmdc:\n%s", html)
+	}
+	if got := out.String(); strings.Contains(got, "graph TD") {
+		t.Errorf("posted render copied to the render output:\n%s", got)
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// assetsPrefix is the route serving files next to the previewed document.
+const assetsPrefix = "/assets/"
+
+// urlAttrs lists, per element, the attribute that may hold a document
+// relative URL.
+var urlAttrs = map[string]string{
+	"a":      "href",
+	"link":   "href",
+	"img":    "src",
+	"source": "src",
+	"video":  "src",
+	"audio":  "src",
+	"script": "src",
+}
+
+// rewriteAssetURLs returns a transform pointing relative links and images in
+// a render of the document at doc at the assets route, so that they resolve
+// against the document's directory. In directory mode, links to other
+// Markdown files open them in the preview instead. With several files, the
+// asset URLs name the document, whose directory they are served from. The
+// files pointed at are recorded as the document's assets.
+func (s *Server) rewriteAssetURLs(doc string) transform {
+	base, dir := "", filepath.Dir(doc)
+	if s.dir {
+		base, dir = path.Dir(s.relativePath(doc)), s.path
+	}
+	return func(root *html.Node) {
+		refs := make(map[string]bool)
+		walk(root, func(n *html.Node) bool {
+			if n.Type != html.ElementNode {
+				return true
+			}
+			key, ok := urlAttrs[n.Data]
+			if !ok {
+				return true
+			}
+			val, ok := attr(n, key)
+			if !ok {
+				return true
+			}
+			u, ok := relativeURL(val, base)
+			if !ok || outsideRoot(u.Path) {
+				// Files above the asset root can't be served, so links
+				// to them are left as written.
+				return true
+			}
+			if s.dir && n.Data == "a" && isDocument(u.Path) {
+				u.RawQuery = url.Values{"file": {u.Path}}.Encode()
+				u.Path = s.route
+			} else {
+				refs[filepath.Join(dir, filepath.FromSlash(u.Path))] = true
+				u.Path = assetsPrefix + u.Path
+				if s.panes() {
+					q := u.Query()
+					q.Set("file", s.documentName(doc))
+					u.RawQuery = q.Encode()
+				}
+			}
+			setAttr(n, key, u.String())
+			return true
+		})
+		s.assetRefsMu.Lock()
+		s.assetRefs[doc] = refs
+		s.assetRefsMu.Unlock()
+	}
+}
+
+// referencedAsset reports whether the last render of doc pointed at the
+// file full through the assets route.
+func (s *Server) referencedAsset(doc, full string) bool {
+	s.assetRefsMu.Lock()
+	defer s.assetRefsMu.Unlock()
+	return s.assetRefs[doc][full]
+}
+
+// relativeURL parses a document relative URL and resolves its path against
+// base, reporting false for absolute URLs and same-document references. The
+// path is cleaned, so it only starts with .. if it leads above base's root.
+func relativeURL(ref, base string) (*url.URL, bool) {
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return nil, false
+	}
+	// The path changes, so any escaping given in ref no longer applies.
+	u.Path, u.RawPath = path.Join(base, u.Path), ""
+	return u, true
+}
+
+// outsideRoot reports whether p, a path cleaned by relativeURL, leads out
+// of the directory it is relative to.
+func outsideRoot(p string) bool {
+	return p == ".." || strings.HasPrefix(p, "../")
+}
+
+// assetRoot is the directory the assets route serves r from: the previewed
+// directory, or that of the file r is for.
+func (s *Server) assetRoot(r *http.Request) (string, error) {
+	if s.dir {
+		return s.path, nil
+	}
+	doc, err := s.documentPath(r)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(doc), nil
+}
+
+// handleAsset serves files from the asset root, refusing anything that would
+// resolve outside of it.
+func (s *Server) handleAsset(w http.ResponseWriter, r *http.Request) {
+	rel := strings.TrimPrefix(r.URL.Path, assetsPrefix)
+	for _, elem := range strings.Split(rel, "/") {
+		if elem == ".." {
+			http.Error(w, "invalid asset path", http.StatusBadRequest)
+			return
+		}
+	}
+
+	root, err := s.assetRoot(r)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	full, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(path.Clean("/"+rel))))
+	if err != nil || !withinDir(root, full) {
+		http.NotFound(w, r)
+		return
+	}
+	if info, err := os.Stat(full); err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, full)
+}
+
+// withinDir reports whether target is dir or inside it. Both must be clean.
+func withinDir(dir, target string) bool {
+	rel, err := filepath.Rel(dir, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// handleCustomCSS serves the user's stylesheet, read afresh every time so
+// that edits show on reload.
+func (s *Server) handleCustomCSS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	http.ServeFile(w, r, s.customCSS)
+}
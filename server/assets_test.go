@@ -0,0 +1,121 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewriteAssetURLs(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{})
+	tests := []struct {
+		in, want string
+	}{
+		{`<img src="img/a.png"/>`, `<img src="/assets/img/a.png"/>`},
+		{`<img src="./img/a b.png"/>`, `<img src="/assets/img/a%20b.png"/>`},
+		{`<img src="img/a%20b.png"/>`, `<img src="/assets/img/a%20b.png"/>`},
+		{`<a href="notes.md#part">x</a>`, `<a href="/assets/notes.md#part">x</a>`},
+		{`<a href="img/../b.png?v=1">x</a>`, `<a href="/assets/b.png?v=1">x</a>`},
+		// Files above the document's directory can't be served.
+		{`<img src="../up.png"/>`, `<img src="../up.png"/>`},
+		{`<img src="img/../../up.png"/>`, `<img src="img/../../up.png"/>`},
+		{`<a href="https://example.com/a.png">x</a>`, `<a href="https://example.com/a.png">x</a>`},
+		{`<a href="/abs.png">x</a>`, `<a href="/abs.png">x</a>`},
+		{`<a href="#heading">x</a>`, `<a href="#heading">x</a>`},
+	}
+	for _, tt := range tests {
+		got, err := postProcess([]byte(tt.in), s.rewriteAssetURLs(s.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s rewritten to %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteAssetURLsInDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "guide"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(dir, "guide", "intro.md")
+	if err := os.WriteFile(doc, []byte("# Intro\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServerFor(t, []string{dir}, Options{})
+	tests := []struct {
+		in, want string
+	}{
+		{`<img src="../logo.png"/>`, `<img src="/assets/logo.png"/>`},
+		{`<img src="shot.png"/>`, `<img src="/assets/guide/shot.png"/>`},
+		{`<a href="../README.md">x</a>`, `<a href="/?file=README.md">x</a>`},
+		{`<img src="../../outside.png"/>`, `<img src="../../outside.png"/>`},
+	}
+	for _, tt := range tests {
+		got, err := postProcess([]byte(tt.in), s.rewriteAssetURLs(doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s rewritten to %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestServeAssets(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "docs")
+	if err := os.MkdirAll(filepath.Join(dir, "img"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(dir, "doc.md"):          "![shot](img/shot.png)\n",
+		filepath.Join(dir, "img", "shot.png"): "png",
+		filepath.Join(root, "secret.txt"):     "secret",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newTestServerFor(t, []string{filepath.Join(dir, "doc.md")}, Options{})
+	ts := startTestServer(t, s)
+
+	ws := dialTestServer(t, ts, "")
+	if msg := readType(t, ws, "render"); !strings.Contains(msg.HTML, `src="/assets/img/shot.png"`) {
+		t.Fatalf("image not pointed at the assets route: %s", msg.HTML)
+	}
+	for path, want := range map[string]int{
+		"/assets/img/shot.png":    http.StatusOK,
+		"/assets/img/missing.png": http.StatusNotFound,
+		"/assets/img":             http.StatusNotFound,
+	} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s: status %d, want %d", path, resp.StatusCode, want)
+		}
+		if want == http.StatusOK && string(body) != "png" {
+			t.Errorf("GET %s served %q", path, body)
+		}
+	}
+	for _, path := range []string{"/assets/../secret.txt", "/assets/%2e%2e/secret.txt", "/assets/img/..%2f..%2fsecret.txt"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK || strings.Contains(string(body), "secret") {
+			t.Errorf("GET %s served a file outside the document's directory", path)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+)
+
+// guard admits trusted requests: with basic auth configured, those with the
+// credentials; otherwise local ones, or any when there's no share key
+// either. Untrusted requests with a valid share link get in read-only.
+func (s *Server) guard(next http.Handler) http.Handler {
+	if s.basicAuth == "" && len(s.shareKey) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.trusted(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if doc, ok := s.shareGrant(w, r); ok {
+			if !s.shareAllows(r, doc) {
+				http.Error(w, "the share link doesn't cover this", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), readOnlyKey{}, true)))
+			return
+		}
+		if s.basicAuth != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mdpreview", charset="UTF-8"`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "a valid, unexpired share link is required", http.StatusForbidden)
+	})
+}
+
+// trusted reports whether r may view and change everything.
+func (s *Server) trusted(r *http.Request) bool {
+	if s.basicAuth == "" {
+		return isLoopback(r)
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	// Compare digests so that the time taken doesn't depend on the length.
+	got := sha256.Sum256([]byte(user + ":" + pass))
+	want := sha256.Sum256([]byte(s.basicAuth))
+	return subtle.ConstantTimeCompare(got[:], want[:]) == 1
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Autolink modes accepted by Options.Autolink.
+const (
+	// AutolinkOn links bare URLs with a scheme, such as https://example.com.
+	AutolinkOn = "on"
+	// AutolinkOff leaves bare URLs as plain text.
+	AutolinkOff = "off"
+	// AutolinkWWW also links bare www. addresses without a scheme.
+	AutolinkWWW = "www"
+)
+
+func validAutolink(mode string) error {
+	switch mode {
+	case AutolinkOn, AutolinkOff, AutolinkWWW:
+		return nil
+	}
+	return fmt.Errorf("unknown autolink mode %q, expected %s, %s or %s", mode, AutolinkOn, AutolinkOff, AutolinkWWW)
+}
+
+// wwwPattern matches a www. address, leaving off trailing punctuation that
+// more likely ends the sentence than the address.
+var wwwPattern = regexp.MustCompile(`\bwww\.[^\s<>"]*[^\s<>".,:;!?')\]]`)
+
+// sourceLink is a link written in Markdown source whose text is an
+// address.
+type sourceLink struct {
+	text string
+	// bare marks an address written as plain text, which renderers link by
+	// themselves, rather than an explicit link.
+	bare bool
+}
+
+var (
+	// bareAddress matches a URL with a scheme, a www. address or an email
+	// address at the start of text, leaving off trailing punctuation that
+	// more likely ends the sentence than the address.
+	bareAddress = regexp.MustCompile(`^(?:(?:https?|ftp)://[^\s<>]*[^\s<>.,:;!?'")\]*_~]|www\.[^\s<>]*[^\s<>.,:;!?'")\]*_~]|[\w.+-]+@[\w-]+(?:\.[\w-]+)+)`)
+	// angleAutolink matches a <...> autolink.
+	angleAutolink = regexp.MustCompile(`^<([a-zA-Z][a-zA-Z0-9+.-]{1,31}:[^\s<>]*|[\w.+-]+@[\w-]+(?:\.[\w-]+)+)>`)
+	// rawLink matches a raw HTML link element.
+	rawLink = regexp.MustCompile(`(?is)^<a\s[^>]*>(.*?)</a\s*>`)
+)
+
+// sourceLinks lists the links in Markdown source whose text is an address,
+// in document order: bare addresses and explicit links written as
+// [text](url), [text][ref], <url> or <a> elements. Code is skipped.
+func sourceLinks(src []byte) []sourceLink {
+	lines := sourceLines(src)
+	labels := map[string]bool{}
+	for _, line := range lines {
+		if m := linkDef.FindString(line); m != "" {
+			label := strings.TrimSpace(m)
+			labels[strings.ToLower(label[1:len(label)-2])] = true
+		}
+	}
+
+	var links []sourceLink
+	var text strings.Builder
+	flush := func() {
+		links = scanLinks(text.String(), labels, links)
+		text.Reset()
+	}
+	for i := 0; i < len(lines); {
+		switch {
+		case fenceOpen.MatchString(lines[i]):
+			flush()
+			i = skipFence(lines, i)
+			continue
+		case linkDef.MatchString(lines[i]):
+			// Definitions render nothing.
+		default:
+			text.WriteString(lines[i])
+		}
+		text.WriteByte('\n')
+		i++
+	}
+	flush()
+	return links
+}
+
+// scanLinks appends the links in text, outside of fenced code blocks, to
+// links. labels are the defined link reference labels, lower-cased.
+func scanLinks(text string, labels map[string]bool, links []sourceLink) []sourceLink {
+	explicit := func(linkText string) {
+		if linkText = strings.TrimSpace(linkText); bareAddress.FindString(linkText) == linkText {
+			links = append(links, sourceLink{text: linkText})
+		}
+	}
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == '\\' && i+1 < len(text):
+			i += 2
+		case c == '`':
+			n := runLength(text[i:], '`')
+			if end := strings.Index(text[i+n:], text[i:i+n]); end >= 0 {
+				i += n + end + n
+			} else {
+				i += n
+			}
+		case c == '<':
+			if m := angleAutolink.FindStringSubmatch(text[i:]); m != nil {
+				explicit(m[1])
+				i += len(m[0])
+			} else if m := rawLink.FindStringSubmatch(text[i:]); m != nil {
+				explicit(m[1])
+				i += len(m[0])
+			} else if loc := htmlTag.FindStringIndex(text[i:]); loc != nil && loc[0] == 0 {
+				// Other tags and comments, whose attributes aren't text.
+				i += loc[1]
+			} else {
+				i++
+			}
+		case c == '[':
+			end := closingBracket(text, i)
+			if end < 0 {
+				i++
+				continue
+			}
+			label := text[i+1 : end]
+			switch {
+			case strings.HasPrefix(text[end+1:], "("):
+				if close := strings.IndexByte(text[end+1:], ')'); close >= 0 {
+					explicit(label)
+					i = end + 1 + close + 1
+					continue
+				}
+			case strings.HasPrefix(text[end+1:], "["):
+				if close := strings.IndexByte(text[end+1:], ']'); close >= 0 {
+					explicit(label)
+					i = end + 1 + close + 1
+					continue
+				}
+			case labels[strings.ToLower(label)]:
+				explicit(label)
+				i = end + 1
+				continue
+			}
+			// Brackets that aren't a link are text, which may hold bare
+			// addresses.
+			i++
+		default:
+			if i > 0 && wordByte(text[i-1]) {
+				i++
+				continue
+			}
+			if m := bareAddress.FindString(text[i:]); m != "" {
+				links = append(links, sourceLink{text: m, bare: true})
+				i += len(m)
+				continue
+			}
+			i++
+		}
+	}
+	return links
+}
+
+// closingBracket returns the index of the ] closing the [ at start in text,
+// or -1.
+func closingBracket(text string, start int) int {
+	depth := 0
+	for i := start; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				return i
+			}
+		case '\n':
+			if i+1 < len(text) && text[i+1] == '\n' {
+				return -1
+			}
+		}
+	}
+	return -1
+}
+
+// wordByte reports whether c continues a word or address, so that an
+// address can't start after it.
+func wordByte(c byte) bool {
+	return c == '_' || c == '.' || c == '+' || c == '-' || c == '@' || c == '/' || c == ':' ||
+		'0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// unlinkBareURLs returns a transform replacing the links a renderer made of
+// bare addresses in source with their text. Rendered links are matched up
+// in order with the links in source by their text, so explicit links are
+// left alone even where their text is their address.
+func unlinkBareURLs(source []sourceLink) transform {
+	pending := map[string][]bool{}
+	for _, l := range source {
+		pending[l.text] = append(pending[l.text], l.bare)
+	}
+	return func(root *html.Node) {
+		walk(root, func(n *html.Node) bool {
+			if n.DataAtom != atom.A {
+				return true
+			}
+			text := textContent(n)
+			queue := pending[text]
+			if len(queue) == 0 {
+				return false
+			}
+			bare := queue[0]
+			pending[text] = queue[1:]
+			if bare {
+				n.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: text}, n)
+				n.Parent.RemoveChild(n)
+			}
+			return false
+		})
+	}
+}
+
+// linkWWW links bare www. addresses in text outside of links and code.
+func linkWWW(root *html.Node) {
+	walk(root, func(n *html.Node) bool {
+		switch n.DataAtom {
+		case atom.A, atom.Code, atom.Pre:
+			return false
+		}
+		if n.Type != html.TextNode || !strings.Contains(n.Data, "www.") {
+			return true
+		}
+
+		text := n.Data
+		matches := wwwPattern.FindAllStringIndex(text, -1)
+		last := 0
+		for _, m := range matches {
+			n.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: text[last:m[0]]}, n)
+			addr := text[m[0]:m[1]]
+			a := &html.Node{
+				Type:     html.ElementNode,
+				Data:     "a",
+				DataAtom: atom.A,
+				Attr: []html.Attribute{
+					{Key: "href", Val: "http://" + addr},
+					{Key: "rel", Val: "nofollow"},
+				},
+			}
+			a.AppendChild(&html.Node{Type: html.TextNode, Data: addr})
+			n.Parent.InsertBefore(a, n)
+			last = m[1]
+		}
+		n.Data = text[last:]
+		return true
+	})
+}
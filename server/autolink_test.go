@@ -0,0 +1,78 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSourceLinks(t *testing.T) {
+	src := "Bare https://example.com/a, www.example.org and me@example.com.\n" +
+		"Explicit [https://example.com/b](https://example.com/b), <https://example.com/c>,\n" +
+		"[text](https://example.com/d), [https://example.com/e][ref] and [https://example.com/f].\n" +
+		"Raw <a href=\"https://example.com/g\">https://example.com/g</a>, <img src=\"https://example.com/h.png\">\n" +
+		"`https://example.com/code` and [see https://example.com/i] and \\https://x.\n" +
+		"\n" +
+		"```\n" +
+		"https://example.com/fenced\n" +
+		"```\n" +
+		"\n" +
+		"[ref]: https://example.com/e\n" +
+		"[https://example.com/f]: https://example.com/f\n"
+	want := []sourceLink{
+		{"https://example.com/a", true},
+		{"www.example.org", true},
+		{"me@example.com", true},
+		{"https://example.com/b", false},
+		{"https://example.com/c", false},
+		{"https://example.com/e", false},
+		{"https://example.com/f", false},
+		{"https://example.com/g", false},
+		{"https://example.com/i", true},
+	}
+	if got := sourceLinks([]byte(src)); !reflect.DeepEqual(got, want) {
+		t.Errorf("sourceLinks =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestAutolink(t *testing.T) {
+	const src = "Bare https://example.com/a and www.example.org.\n\n" +
+		"Explicit [https://example.com/a](https://example.com/a) and <https://example.com/b> and [text](https://example.com/c).\n"
+	const (
+		bare     = `<a href="https://example.com/a" rel="nofollow">https://example.com/a</a> and`
+		bareText = `Bare https://example.com/a and`
+		www      = `<a href="http://www.example.org" rel="nofollow">www.example.org</a>`
+		explicit = `Explicit <a href="https://example.com/a" rel="nofollow">https://example.com/a</a> and <a href="https://example.com/b" rel="nofollow">https://example.com/b</a> and <a href="https://example.com/c" rel="nofollow">text</a>.`
+	)
+	tests := []struct {
+		mode          string
+		want, notWant []string
+	}{
+		{AutolinkOn, []string{"Bare " + bare, explicit}, []string{www}},
+		{AutolinkOff, []string{bareText, "www.example.org.", explicit}, []string{www, "Bare <a"}},
+		{AutolinkWWW, []string{"Bare " + bare, www, explicit}, nil},
+	}
+	for _, engine := range []string{EngineGFM, EngineGoldmark} {
+		for _, tt := range tests {
+			s := newTestServer(t, "doc.md", src, Options{Engine: engine, Autolink: tt.mode})
+			rendered, err := s.Render()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(rendered), want) {
+					t.Errorf("%s, -autolink %s: render lacks %s:\n%s", engine, tt.mode, want, rendered)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				// Goldmark links www. addresses itself.
+				if notWant == www && engine == EngineGoldmark && tt.mode == AutolinkOn {
+					continue
+				}
+				if strings.Contains(string(rendered), notWant) {
+					t.Errorf("%s, -autolink %s: render has %s:\n%s", engine, tt.mode, notWant, rendered)
+				}
+			}
+		}
+	}
+}
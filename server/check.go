@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is something wrong with a document found by Check.
+type Problem struct {
+	// Line is the source line of the block with the problem, or 0 when
+	// unknown, as for HTML files.
+	Line    int
+	Message string
+	// Warning problems are less serious than errors, such as accessibility
+	// issues.
+	Warning bool
+}
+
+func (p Problem) String() string {
+	kind := "error"
+	if p.Warning {
+		kind = "warning"
+	}
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", kind, p.Message)
+	}
+	return fmt.Sprintf("%d: %s: %s", p.Line, kind, p.Message)
+}
+
+// Check renders the file once, as Render does but without drawing diagrams
+// with mmdc, and reports what is wrong with it: front matter that isn't
+// valid YAML, links and images pointing at missing files or headings, JSON,
+// YAML and TOML code blocks that don't parse with ValidateCode, and
+// accessibility issues, as warnings, with A11y.
+func (s *Server) Check() ([]Problem, error) {
+	if s.dir {
+		return nil, fmt.Errorf("%s is a directory; check a file instead", s.path)
+	}
+	input, err := s.readFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	rendered, _, err := s.renderInput(s.path, input, renderRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []Problem
+	if _, front, ok := s.frontMatter(input); ok && len(bytes.TrimSpace(front)) > 0 && frontMatterMapping(front) == nil {
+		var v interface{}
+		msg := "front matter is not a YAML mapping"
+		if err := yaml.Unmarshal(front, &v); err != nil {
+			msg = fmt.Sprintf("invalid front matter: %v", err)
+		}
+		problems = append(problems, Problem{Line: 1, Message: msg})
+	}
+
+	nodes, err := html.ParseFragment(bytes.NewReader(rendered), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]bool{}
+	for _, n := range nodes {
+		walk(n, func(n *html.Node) bool {
+			if id, ok := attr(n, "id"); ok {
+				ids[id] = true
+			}
+			if name, ok := attr(n, "name"); ok && n.DataAtom == atom.A {
+				ids[name] = true
+			}
+			return true
+		})
+	}
+
+	// Warnings are inserted before the block they are about, so the line of
+	// a top-level warning is that of the next block.
+	var pending []Problem
+	for _, block := range nodes {
+		if block.Type != html.ElementNode {
+			continue
+		}
+		if p, ok := warningProblem(block); ok {
+			pending = append(pending, p)
+			continue
+		}
+
+		line := 0
+		if v, ok := attr(block, "data-source-line"); ok {
+			line, _ = strconv.Atoi(v)
+		}
+		for _, p := range pending {
+			p.Line = line
+			problems = append(problems, p)
+		}
+		pending = nil
+		walk(block, func(n *html.Node) bool {
+			if p, ok := warningProblem(n); ok {
+				p.Line = line
+				problems = append(problems, p)
+			} else if msg := s.brokenLink(n, ids); msg != "" {
+				problems = append(problems, Problem{Line: line, Message: msg})
+			}
+			return true
+		})
+	}
+	return append(problems, pending...), nil
+}
+
+// warningProblem turns a warning the render marked, by validateCode or
+// checkAccessibility, into a problem.
+func warningProblem(n *html.Node) (Problem, bool) {
+	if n.Type != html.ElementNode || n.DataAtom != atom.P {
+		return Problem{}, false
+	}
+	switch class, _ := attr(n, "class"); class {
+	case "code-warning":
+		return Problem{Message: textContent(n)}, true
+	case "a11y-warning":
+		msg := strings.TrimPrefix(textContent(n), "Accessibility: ")
+		return Problem{Message: "accessibility: " + msg, Warning: true}, true
+	}
+	return Problem{}, false
+}
+
+// brokenLink describes what is wrong with the link or image n, if it points
+// at a heading missing from ids or a file missing next to the document.
+func (s *Server) brokenLink(n *html.Node, ids map[string]bool) string {
+	if n.Type != html.ElementNode || (n.DataAtom != atom.A && n.DataAtom != atom.Img) {
+		return ""
+	}
+	key := "href"
+	if n.DataAtom == atom.Img {
+		key = "src"
+	}
+	ref, ok := attr(n, key)
+	if !ok {
+		return ""
+	}
+	if strings.HasPrefix(ref, "#") {
+		frag, err := url.PathUnescape(ref[1:])
+		if err == nil && frag != "" && !ids[frag] {
+			return fmt.Sprintf("link to missing heading %s", ref)
+		}
+		return ""
+	}
+	u, ok := relativeURL(ref, "")
+	if !ok {
+		return ""
+	}
+	target := filepath.Join(filepath.Dir(s.path), filepath.FromSlash(u.Path))
+	if _, err := os.Stat(target); err != nil {
+		if n.DataAtom == atom.Img {
+			return fmt.Sprintf("image %s not found", u.Path)
+		}
+		return fmt.Sprintf("link to missing file %s", u.Path)
+	}
+	return ""
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// comment is a review note attached to a line of the document.
+type comment struct {
+	ID   string `json:"id"`
+	Line int    `json:"line"`
+	// Anchor is the text of the line when the comment was made, used to
+	// find the line again after edits move it.
+	Anchor  string    `json:"anchor"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created"`
+}
+
+// commentsPath is the sidecar file holding the comments on the document at
+// path.
+func commentsPath(path string) string {
+	return path + ".comments.json"
+}
+
+// loadComments reads the sidecar of the document at path. A missing sidecar
+// means no comments.
+func loadComments(path string) ([]comment, error) {
+	data, err := os.ReadFile(commentsPath(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var comments []comment
+	if err := json.Unmarshal(data, &comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// storeComments replaces the sidecar of the document at path, removing it
+// once no comments remain.
+func storeComments(path string, comments []comment) error {
+	if len(comments) == 0 {
+		if err := os.Remove(commentsPath(path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(comments, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := commentsPath(path) + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, commentsPath(path))
+}
+
+// reanchor moves each comment whose line no longer reads as its anchor to
+// the nearest line that does. Comments whose anchor is gone stay put,
+// clamped to the document.
+func reanchor(comments []comment, lines []string) {
+	for i := range comments {
+		c := &comments[i]
+		if c.Line < 1 {
+			c.Line = 1
+		}
+		if c.Line > len(lines) {
+			c.Line = len(lines)
+		}
+		anchor := strings.TrimSpace(c.Anchor)
+		if anchor == "" || strings.TrimSpace(lines[c.Line-1]) == anchor {
+			continue
+		}
+		for d := 1; d < len(lines); d++ {
+			if up := c.Line - 1 - d; up >= 0 && strings.TrimSpace(lines[up]) == anchor {
+				c.Line = up + 1
+				break
+			}
+			if down := c.Line - 1 + d; down < len(lines) && strings.TrimSpace(lines[down]) == anchor {
+				c.Line = down + 1
+				break
+			}
+		}
+	}
+	sort.SliceStable(comments, func(i, j int) bool { return comments[i].Line < comments[j].Line })
+}
+
+// attachComments returns a transform that places each comment as an aside
+// after the top-level element of the block holding its line. It relies on
+// data-source-line, so must run after annotateSourceLines. Live renders get
+// a delete button on each comment.
+func attachComments(comments []comment, live bool) transform {
+	return func(root *html.Node) {
+		var blocks []*html.Node
+		for n := root.FirstChild; n != nil; n = n.NextSibling {
+			if _, ok := attr(n, "data-source-line"); ok {
+				blocks = append(blocks, n)
+			}
+		}
+		if len(blocks) == 0 {
+			return
+		}
+		// Asides for one block are inserted last first to keep their order.
+		for i := len(comments) - 1; i >= 0; i-- {
+			c := comments[i]
+			block := blocks[0]
+			for _, b := range blocks {
+				line, _ := attr(b, "data-source-line")
+				if start, _ := strconv.Atoi(line); start > c.Line {
+					break
+				}
+				block = b
+			}
+			root.InsertBefore(commentNode(c, live), block.NextSibling)
+		}
+	}
+}
+
+func commentNode(c comment, live bool) *html.Node {
+	aside := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "aside",
+		DataAtom: atom.Aside,
+		Attr: []html.Attribute{
+			{Key: "class", Val: "comment"},
+			{Key: "data-comment-id", Val: c.ID},
+		},
+	}
+	if live {
+		button := &html.Node{
+			Type:     html.ElementNode,
+			Data:     "button",
+			DataAtom: atom.Button,
+			Attr: []html.Attribute{
+				{Key: "type", Val: "button"},
+				{Key: "class", Val: "comment-delete"},
+				{Key: "title", Val: "Delete comment"},
+			},
+		}
+		button.AppendChild(&html.Node{Type: html.TextNode, Data: "×"})
+		aside.AppendChild(button)
+	}
+	aside.AppendChild(&html.Node{Type: html.TextNode, Data: c.Text})
+	return aside
+}
+
+// handleGetComments lists the comments, anchored to the current document.
+func (s *Server) handleGetComments(w http.ResponseWriter, r *http.Request) {
+	path, err := s.documentPath(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.commentsMu.Lock()
+	comments, err := loadComments(path)
+	s.commentsMu.Unlock()
+	if err != nil {
+		s.log.WithError(err).Error("failed to load comments")
+		http.Error(w, "Failed to load comments", http.StatusInternalServerError)
+		return
+	}
+	if input, err := s.readFile(path); err == nil {
+		reanchor(comments, sourceLines(input))
+	}
+	if comments == nil {
+		comments = []comment{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comments)
+}
+
+// handleAddComment stores a comment on a line, given as JSON
+// {"line":N,"text":"..."}, and re-renders the preview.
+func (s *Server) handleAddComment(w http.ResponseWriter, r *http.Request) {
+	if !s.checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if readOnly(r) {
+		http.Error(w, "shared previews are read-only", http.StatusForbidden)
+		return
+	}
+	path, err := s.documentPath(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var req struct {
+		Line int    `json:"line"`
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil || strings.TrimSpace(req.Text) == "" || req.Line < 1 {
+		http.Error(w, "expected a line and non-empty text", http.StatusBadRequest)
+		return
+	}
+	input, err := s.readFile(path)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+	lines := sourceLines(input)
+	if req.Line > len(lines) {
+		http.Error(w, "line is past the end of the document", http.StatusBadRequest)
+		return
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	c := comment{
+		ID:      hex.EncodeToString(id),
+		Line:    req.Line,
+		Anchor:  lines[req.Line-1],
+		Text:    strings.TrimSpace(req.Text),
+		Created: time.Now().UTC(),
+	}
+
+	s.commentsMu.Lock()
+	comments, err := loadComments(path)
+	if err == nil {
+		// Persist where existing comments have moved to while at it.
+		reanchor(comments, lines)
+		err = storeComments(path, append(comments, c))
+	}
+	s.commentsMu.Unlock()
+	if err != nil {
+		s.log.WithError(err).Error("failed to save comment")
+		http.Error(w, "Failed to save comment", http.StatusInternalServerError)
+		return
+	}
+
+	s.refresh(path)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// handleDeleteComment removes a comment and re-renders the preview.
+func (s *Server) handleDeleteComment(w http.ResponseWriter, r *http.Request) {
+	if !s.checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if readOnly(r) {
+		http.Error(w, "shared previews are read-only", http.StatusForbidden)
+		return
+	}
+	path, err := s.documentPath(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	s.commentsMu.Lock()
+	comments, err := loadComments(path)
+	found := false
+	if err == nil {
+		kept := comments[:0]
+		for _, c := range comments {
+			if c.ID == id {
+				found = true
+				continue
+			}
+			kept = append(kept, c)
+		}
+		if found {
+			err = storeComments(path, kept)
+		}
+	}
+	s.commentsMu.Unlock()
+	if err != nil {
+		s.log.WithError(err).Error("failed to delete comment")
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.refresh(path)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReanchor(t *testing.T) {
+	comments := []comment{
+		{ID: "same", Line: 2, Anchor: "Second"},
+		{ID: "moved", Line: 1, Anchor: "First"},
+		{ID: "gone", Line: 9, Anchor: "Deleted"},
+	}
+	// A line was inserted at the top and the document got shorter.
+	reanchor(comments, []string{"New", "First", "Second", "Third"})
+	got := map[string]int{}
+	for _, c := range comments {
+		got[c.ID] = c.Line
+	}
+	want := map[string]int{"moved": 2, "same": 3, "gone": 4}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("lines after reanchor = %v, want %v", got, want)
+	}
+	for i := 1; i < len(comments); i++ {
+		if comments[i-1].Line > comments[i].Line {
+			t.Errorf("comments out of line order: %+v", comments)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Title\n\nFirst paragraph.\n\nSecond paragraph.\n", Options{Comments: true})
+	ts := startTestServer(t, s)
+	ws := dialTestServer(t, ts, "")
+	readConnected(t, ws)
+
+	resp, err := http.Post(ts.URL+"/comments", "application/json", strings.NewReader(`{"line":5,"text":"Reword this"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var added comment
+	json.NewDecoder(resp.Body).Decode(&added)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || added.Anchor != "Second paragraph." {
+		t.Fatalf("adding a comment: status %d, %+v", resp.StatusCode, added)
+	}
+
+	// The comment is kept in the sidecar and shown after its block.
+	stored, err := loadComments(s.path)
+	if err != nil || len(stored) != 1 || stored[0].Text != "Reword this" {
+		t.Fatalf("sidecar holds %+v, %v", stored, err)
+	}
+	msg := waitForRender(t, ws, "Reword this")
+	if !strings.Contains(msg.HTML, `Second paragraph.</p><aside class="comment" data-comment-id="`+added.ID+`">`) {
+		t.Errorf("comment not placed after its paragraph:\n%s", msg.HTML)
+	}
+
+	// Editing above the comment moves it with its line.
+	if err := os.WriteFile(s.path, []byte("# Title\n\nNew paragraph.\n\nFirst paragraph.\n\nSecond paragraph.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForRender(t, ws, "New paragraph.")
+	resp, err = http.Get(ts.URL + "/comments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []comment
+	json.NewDecoder(resp.Body).Decode(&listed)
+	resp.Body.Close()
+	if len(listed) != 1 || listed[0].Line != 7 {
+		t.Errorf("comments after the edit = %+v, want it on line 7", listed)
+	}
+
+	req, err := http.NewRequest("DELETE", ts.URL+"/comments/"+added.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("deleting the comment: status %d", resp.StatusCode)
+	}
+	if _, err := os.Stat(commentsPath(s.path)); !os.IsNotExist(err) {
+		t.Errorf("sidecar left behind without comments: %v", err)
+	}
+}
+
+func TestCommentsRefused(t *testing.T) {
+	s := newTestServer(t, "doc.md", "Text\n", Options{Comments: true})
+	ts := startTestServer(t, s)
+	for _, body := range []string{`{"line":0,"text":"x"}`, `{"line":1,"text":"  "}`, `{"line":9,"text":"x"}`, `not json`} {
+		resp, err := http.Post(ts.URL+"/comments", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("comment %s: status %d, want 400", body, resp.StatusCode)
+		}
+	}
+
+	req, err := http.NewRequest("POST", ts.URL+"/comments", strings.NewReader(`{"line":1,"text":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "https://evil.example")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin comment: status %d, want 403", resp.StatusCode)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// coverFields are the front matter fields shown on a cover, in order.
+var coverFields = []string{"title", "subtitle", "author", "date"}
+
+// coverPage returns a transform that puts a title block at the top of the
+// render, made of the title, subtitle, author and date fields of the front
+// matter. ok is false if it has none of them.
+func coverPage(front []byte) (t transform, ok bool) {
+	mapping := frontMatterMapping(front)
+	if mapping == nil {
+		return nil, false
+	}
+	fields := map[string]*html.Node{}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key := mapping.Content[i].Value
+		for _, f := range coverFields {
+			if n := frontMatterValue(mapping.Content[i+1]); key == f && textContent(n) != "" {
+				fields[f] = n
+			}
+		}
+	}
+	if len(fields) == 0 {
+		return nil, false
+	}
+
+	return func(root *html.Node) {
+		header := element("header", atom.Header, "cover")
+		header.Attr = append(header.Attr, html.Attribute{Key: "data-source-line", Val: "1"})
+		for _, f := range coverFields {
+			if value, ok := fields[f]; ok {
+				p := element("p", atom.P, "cover-"+f)
+				p.AppendChild(value)
+				header.AppendChild(p)
+			}
+		}
+		root.InsertBefore(header, root.FirstChild)
+	}, true
+}
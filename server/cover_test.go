@@ -0,0 +1,66 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCover(t *testing.T) {
+	const src = "---\ntitle: Annual Report\nsubtitle: Fiscal 2024\nauthor: Ann Smith\ndate: 2024-12-31\ntags: [finance]\n---\n\nBody text.\n"
+	s := newTestServer(t, "doc.md", src, Options{Cover: true})
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<header class="cover" data-source-line="1">` +
+		`<p class="cover-title">Annual Report</p>` +
+		`<p class="cover-subtitle">Fiscal 2024</p>` +
+		`<p class="cover-author">Ann Smith</p>` +
+		`<p class="cover-date">2024-12-31</p>` +
+		`</header>`
+	if !strings.HasPrefix(string(rendered), want) {
+		t.Errorf("render doesn't start with the cover:\n%s", rendered)
+	}
+	// The cover takes the place of the front matter table.
+	if strings.Contains(string(rendered), "front-matter") || strings.Contains(string(rendered), "finance") {
+		t.Errorf("front matter shown besides the cover:\n%s", rendered)
+	}
+}
+
+func TestCoverPartial(t *testing.T) {
+	s := newTestServer(t, "doc.md", "---\nauthor: Ann Smith\n---\n\nBody.\n", Options{Cover: true})
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rendered), `<header class="cover" data-source-line="1"><p class="cover-author">Ann Smith</p></header>`) {
+		t.Errorf("cover lacks just the author:\n%s", rendered)
+	}
+	if strings.Contains(string(rendered), "cover-title") {
+		t.Errorf("cover has a title the front matter lacks:\n%s", rendered)
+	}
+}
+
+func TestCoverOmitted(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		opts Options
+	}{
+		{"no cover fields", "---\ntags: [a, b]\nlayout: post\n---\n\nBody.\n", Options{Cover: true}},
+		{"empty fields", "---\ntitle: \"\"\nauthor:\n---\n\nBody.\n", Options{Cover: true}},
+		{"not a mapping", "---\n- a\n- b\n---\n\nBody.\n", Options{Cover: true}},
+		{"no front matter", "# Heading\n\nBody.\n", Options{Cover: true}},
+		{"-cover off", "---\ntitle: Report\n---\n\nBody.\n", Options{}},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, "doc.md", tt.src, tt.opts)
+		rendered, err := s.Render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(rendered), `class="cover`) {
+			t.Errorf("%s: render has a cover:\n%s", tt.name, rendered)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// errNoDocument reports a file parameter that doesn't name a Markdown file
+// under the previewed directory.
+var errNoDocument = errors.New("no such document")
+
+// isDocument reports whether name is listed and served in directory mode.
+func isDocument(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".md")
+}
+
+// skipDir reports whether a directory is left out of the listing and not
+// watched.
+func skipDir(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "node_modules"
+}
+
+// documentPath returns the file a request is for. In directory mode it is
+// the file query parameter, relative to the directory and refused if it
+// would escape it. With several files it is the one the parameter names,
+// the first by default; otherwise it is always the previewed file.
+func (s *Server) documentPath(r *http.Request) (string, error) {
+	return s.documentNamed(r.URL.Query().Get("file"))
+}
+
+// documentNamed returns the file named file, as in the file query
+// parameter of documentPath.
+func (s *Server) documentNamed(file string) (string, error) {
+	if s.panes() {
+		if file == "" {
+			return s.files[0], nil
+		}
+		if p, ok := s.paneFile(file); ok {
+			return p, nil
+		}
+		return "", errNoDocument
+	}
+	if !s.dir {
+		return s.path, nil
+	}
+	for _, elem := range strings.Split(file, "/") {
+		if elem == ".." {
+			return "", errNoDocument
+		}
+	}
+	rel := strings.TrimPrefix(path.Clean("/"+file), "/")
+	if rel == "" || !isDocument(rel) {
+		return "", errNoDocument
+	}
+
+	full := filepath.Join(s.path, filepath.FromSlash(rel))
+	root, err := filepath.EvalSymlinks(s.path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(full)
+	if err != nil || !withinDir(root, resolved) {
+		return "", errNoDocument
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.Mode().IsRegular() {
+		return "", errNoDocument
+	}
+	return full, nil
+}
+
+// relativePath returns the path of a document relative to the previewed
+// directory, with forward slashes.
+func (s *Server) relativePath(p string) string {
+	rel, err := filepath.Rel(s.path, p)
+	if err != nil {
+		return filepath.Base(p)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// listDocuments returns the Markdown files under the previewed directory,
+// relative to it and sorted.
+func (s *Server) listDocuments() ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(s.path, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if p != s.path && skipDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isDocument(entry.Name()) {
+			files = append(files, s.relativePath(p))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// handleListing serves the page linking to every document in the directory.
+func (s *Server) handleListing(w http.ResponseWriter, r *http.Request) {
+	files, err := s.listDocuments()
+	if err != nil {
+		s.log.WithError(err).Error("failed to list directory")
+		http.Error(w, "Failed to list directory", http.StatusInternalServerError)
+		return
+	}
+	data := s.indexData(s.path, false)
+	data["files"] = files
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.listingTemplate.Execute(w, data); err != nil {
+		s.log.WithError(err).Error("failed to render listing")
+	}
+}
+
+// watchDir watches the previewed directory and its subdirectories,
+// scheduling renders of the documents being viewed when they change.
+func (s *Server) watchDir() {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		s.log.WithError(err).Error("failed to create file watcher")
+		return
+	}
+	defer w.Close()
+	s.addWatches(w, s.path)
+	s.addExtraWatches(w)
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			s.log.Debug("watcher shutting down")
+			return
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			s.log.WithFields(logrus.Fields{
+				"file":  event.Name,
+				"event": event.Op,
+			}).Debug("file event")
+
+			if s.extraEvent(w, event) {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() && !skipDir(info.Name()) {
+					s.addWatches(w, event.Name)
+				}
+			}
+			if d := s.lookupDocument(event.Name); d != nil {
+				if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+					d.invalidate()
+				}
+				s.fileChanged(d)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			s.log.WithError(err).Warn("file watcher error")
+		}
+	}
+}
+
+// addWatches watches dir and the directories under it.
+func (s *Server) addWatches(w *fsnotify.Watcher, dir string) {
+	filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+		if p != s.path && skipDir(entry.Name()) {
+			return filepath.SkipDir
+		}
+		if err := w.Add(p); err != nil {
+			s.log.WithError(err).WithField("dir", p).Warn("failed to watch directory")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// document is a file being previewed. Each has its own hub and render loop,
+// so that its renders only reach the clients viewing it.
+type document struct {
+	path    string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	hub     *hub
+	changes chan struct{}
+	// viewers counts the clients viewing the document. In directory mode it
+	// is closed when the last one leaves. Guarded by Server.docsMu.
+	viewers int
+
+	debounceMu sync.Mutex
+	debounce   *time.Timer
+
+	// renderedHash is the hash of the content last rendered and cached the
+	// live render of it, if still valid. reading is that content's length
+	// and title its title.
+	hashMu       sync.Mutex
+	renderedHash [sha256.Size]byte
+	cached       []byte
+	reading      readingStats
+	title        string
+	stats        statsRecorder
+	// savedBy is the client that last saved the document, as savedHash,
+	// while the file still holds what it saved. Guarded by hashMu.
+	savedBy   *client
+	savedHash [sha256.Size]byte
+}
+
+// openDocument returns the document for path, starting to render it if no
+// one is viewing it yet. Every call must be paired with closeDocument.
+func (s *Server) openDocument(path string) *document {
+	path = filepath.Clean(path)
+
+	s.docsMu.Lock()
+	defer s.docsMu.Unlock()
+	if d, ok := s.docs[path]; ok {
+		d.viewers++
+		return d
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	d := &document{
+		path:    path,
+		ctx:     ctx,
+		cancel:  cancel,
+		hub:     newHub(),
+		changes: make(chan struct{}, 1),
+		viewers: 1,
+	}
+	s.docs[path] = d
+	s.goTracked(func() { d.hub.run(d.ctx) })
+	s.goTracked(func() { s.renderLoop(d) })
+	return d
+}
+
+// closeDocument releases a document opened with openDocument. In directory
+// mode a document nobody views any more stops rendering.
+func (s *Server) closeDocument(d *document) {
+	s.docsMu.Lock()
+	defer s.docsMu.Unlock()
+	d.viewers--
+	if d.viewers > 0 || !s.dir {
+		return
+	}
+	delete(s.docs, d.path)
+	d.cancel()
+	d.debounceMu.Lock()
+	if d.debounce != nil {
+		d.debounce.Stop()
+	}
+	d.debounceMu.Unlock()
+}
+
+// lookupDocument returns the open document for path, if any.
+func (s *Server) lookupDocument(path string) *document {
+	s.docsMu.Lock()
+	defer s.docsMu.Unlock()
+	return s.docs[filepath.Clean(path)]
+}
+
+// refresh re-renders the document for path if anyone is viewing it, such as
+// after its comments change.
+func (s *Server) refresh(path string) {
+	if d := s.lookupDocument(path); d != nil {
+		d.invalidate()
+		notify(d.changes)
+	}
+}
+
+// invalidate drops the cached render of d and forgets what content it was
+// of, for when something other than its content changed or the file went
+// away. The next event renders it even with ContentOnly, so that a file
+// restored as it was still replaces the error saying it was missing.
+func (d *document) invalidate() {
+	d.hashMu.Lock()
+	d.cached = nil
+	d.renderedHash = [sha256.Size]byte{}
+	d.hashMu.Unlock()
+}
+
+// saving records that c is about to save content as d, so that the content
+// isn't sent back to c, which already has it and may have gone on editing.
+func (d *document) saving(c *client, content []byte) {
+	d.hashMu.Lock()
+	d.savedBy, d.savedHash = c, sha256.Sum256(content)
+	d.hashMu.Unlock()
+}
+
+// saverOf returns the client that saved content as d, if any. The save is
+// forgotten once d holds anything else, such as an external edit, which goes
+// to every client.
+func (d *document) saverOf(content []byte) *client {
+	d.hashMu.Lock()
+	defer d.hashMu.Unlock()
+	if d.savedBy != nil && sha256.Sum256(content) != d.savedHash {
+		d.savedBy = nil
+	}
+	return d.savedBy
+}
+
+// fileChanged schedules a render of d after a file event. Bursts of events,
+// such as an editor saving in several writes, collapse into one render once
+// the file has been quiet for the debounce window.
+func (s *Server) fileChanged(d *document) {
+	if s.debounce <= 0 {
+		s.notifyChanged(d)
+		return
+	}
+	d.debounceMu.Lock()
+	defer d.debounceMu.Unlock()
+	if d.debounce == nil {
+		d.debounce = time.AfterFunc(s.debounce, func() { s.notifyChanged(d) })
+		return
+	}
+	d.debounce.Reset(s.debounce)
+}
+
+// notifyChanged queues a render after a file event, and has the rest of the
+// reload group re-render too. With ContentOnly, events that leave the content
+// as last rendered are dropped.
+func (s *Server) notifyChanged(d *document) {
+	if s.contentOnly {
+		// Unreadable files fall through so the render reports the error.
+		if input, err := s.readFile(d.path); err == nil {
+			d.hashMu.Lock()
+			same := sha256.Sum256(input) == d.renderedHash
+			d.hashMu.Unlock()
+			if same {
+				s.log.Debug("file content unchanged, skipping render")
+				return
+			}
+		}
+	}
+	notify(d.changes)
+	s.signalReloadGroup()
+}
+
+// notify queues a render without blocking; one pending render already
+// covers any further changes.
+func notify(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
+
+// renderLoop renders d when it opens and on every change after, publishing
+// the result to the clients viewing it. With saving allowed, the new content
+// follows for editors, except to the client that saved it.
+func (s *Server) renderLoop(d *document) {
+	// Hold back the initial render until the file has had time to settle.
+	if wait := time.Until(s.settleUntil); wait > 0 {
+		s.log.WithField("delay", wait).Debug("delaying initial render")
+		if m, err := s.encodeMessage(wsMessage{Type: "render", File: s.documentName(d.path), HTML: settlingHTML}); err == nil {
+			d.hub.publish(d.ctx, m)
+		}
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+	notify(d.changes)
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			s.log.Debug("render loop shutting down")
+			return
+		case <-d.changes:
+			msg := s.renderMessage(d)
+			m, err := s.encodeMessage(msg)
+			if err != nil {
+				s.log.WithError(err).Error("failed to encode message")
+				continue
+			}
+			m.transient = msg.Type == "error"
+			s.log.Debug("broadcasting rendered content")
+			d.hub.publish(d.ctx, m)
+			if s.allowSave {
+				s.publishContent(d)
+			}
+		}
+	}
+}
+
+// publishContent sends the file's content to the clients viewing d, for
+// their editors, skipping the client whose save it is.
+func (s *Server) publishContent(d *document) {
+	content, err := s.readFile(d.path)
+	if err != nil {
+		return
+	}
+	m, err := s.encodeMessage(wsMessage{Type: "content", File: s.documentName(d.path), Content: string(content)})
+	if err != nil {
+		s.log.WithError(err).Error("failed to encode message")
+		return
+	}
+	m.source, m.skip = true, d.saverOf(content)
+	d.hub.publish(d.ctx, m)
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark-emoji/definition"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Emoji styles accepted by Options.EmojiStyle.
+const (
+	// EmojiUnicode replaces shortcodes with Unicode emoji.
+	EmojiUnicode = "unicode"
+	// EmojiTwemoji replaces shortcodes with Twemoji images, which look the
+	// same on every platform.
+	EmojiTwemoji = "twemoji"
+)
+
+func validEmojiStyle(style string) error {
+	switch style {
+	case EmojiUnicode, EmojiTwemoji:
+		return nil
+	}
+	return fmt.Errorf("unknown emoji style %q, expected %s or %s", style, EmojiUnicode, EmojiTwemoji)
+}
+
+// twemojiDir holds the Twemoji SVGs in the embedded static files, fetched by
+// make twemoji.
+const twemojiDir = "static/twemoji"
+
+// twemojiFiles is where the Twemoji SVGs are looked up, under twemojiDir.
+var twemojiFiles fs.FS = staticFiles
+
+// shortcode matches an emoji shortcode such as :rocket: or :+1:.
+var shortcode = regexp.MustCompile(`:[a-z0-9_+-]+:`)
+
+// emojis are GitHub's shortcodes.
+var emojis = definition.Github()
+
+// emoji returns a transform replacing known :shortcode: text outside of
+// code, math and URLs with emoji in the server's style. Twemoji images point
+// at the embedded SVGs, inlined into pages that are saved rather than
+// served; emoji without an embedded SVG are shown as Unicode.
+func (s *Server) emoji(live bool) transform {
+	return func(root *html.Node) {
+		var texts []*html.Node
+		walk(root, func(n *html.Node) bool {
+			switch n.Type {
+			case html.ElementNode:
+				if class, _ := attr(n, "class"); verbatimElements[n.Data] || strings.HasPrefix(class, "math") {
+					return false
+				}
+			case html.TextNode:
+				if len(shortcodes(n.Data)) > 0 {
+					texts = append(texts, n)
+				}
+			}
+			return true
+		})
+		for _, n := range texts {
+			if s.emojiStyle == EmojiTwemoji {
+				twemojiText(n, live)
+			} else {
+				unicodeText(n)
+			}
+		}
+	}
+}
+
+// shortcodes returns the positions of the shortcodes in text, leaving out
+// any in a URL, such as https://host/a:smile:b.
+func shortcodes(text string) [][]int {
+	urls := urlSpan.FindAllStringIndex(text, -1)
+	var locs [][]int
+	for _, loc := range shortcode.FindAllStringIndex(text, -1) {
+		inURL := false
+		for _, u := range urls {
+			if loc[0] < u[1] && u[0] < loc[1] {
+				inURL = true
+				break
+			}
+		}
+		if !inURL {
+			locs = append(locs, loc)
+		}
+	}
+	return locs
+}
+
+// unicodeText replaces the known shortcodes in text node n with Unicode
+// emoji.
+func unicodeText(n *html.Node) {
+	text := n.Data
+	var b strings.Builder
+	last := 0
+	for _, loc := range shortcodes(text) {
+		if e, ok := lookupEmoji(text[loc[0]:loc[1]]); ok {
+			b.WriteString(text[last:loc[0]])
+			b.WriteString(string(e.Unicode))
+			last = loc[1]
+		}
+	}
+	n.Data = b.String() + text[last:]
+}
+
+// lookupEmoji finds the emoji for a shortcode, colons included. GitHub's
+// custom emoji, such as :octocat:, have no Unicode form and aren't found.
+func lookupEmoji(code string) (*definition.Emoji, bool) {
+	e, ok := emojis.Get(strings.Trim(code, ":"))
+	if !ok || !e.IsUnicode() {
+		return nil, false
+	}
+	return e, true
+}
+
+// twemojiText splits text node n around its shortcodes, putting a Twemoji
+// image in place of each known one, or the Unicode emoji when its SVG isn't
+// embedded.
+func twemojiText(n *html.Node, live bool) {
+	text := n.Data
+	var pending strings.Builder
+	last := 0
+	for _, loc := range shortcodes(text) {
+		code := text[loc[0]:loc[1]]
+		e, ok := lookupEmoji(code)
+		if !ok {
+			continue
+		}
+		pending.WriteString(text[last:loc[0]])
+		last = loc[1]
+		src, ok := twemojiURL(e.Unicode, live)
+		if !ok {
+			pending.WriteString(string(e.Unicode))
+			continue
+		}
+		if pending.Len() > 0 {
+			n.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: pending.String()}, n)
+			pending.Reset()
+		}
+		img := element("img", atom.Img, "emoji")
+		img.Attr = append(img.Attr,
+			html.Attribute{Key: "alt", Val: string(e.Unicode)},
+			html.Attribute{Key: "title", Val: code},
+			html.Attribute{Key: "src", Val: src},
+		)
+		n.Parent.InsertBefore(img, n)
+	}
+	n.Data = pending.String() + text[last:]
+}
+
+// twemojiURL is where the image of an emoji is: the embedded SVG, or a data
+// URL of it for pages that aren't served. It reports false when the SVG
+// isn't embedded, since pages don't load anything from elsewhere.
+func twemojiURL(emoji []rune, live bool) (string, bool) {
+	name := twemojiName(emoji)
+	data, err := fs.ReadFile(twemojiFiles, twemojiDir+"/"+name)
+	switch {
+	case err != nil:
+		return "", false
+	case live:
+		return "/twemoji/" + name, true
+	}
+	return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(data), true
+}
+
+// twemojiName is the file name Twemoji gives an emoji: its code points in
+// hex joined with hyphens, leaving out variation selectors unless it is a
+// zero width joiner sequence.
+func twemojiName(emoji []rune) string {
+	zwj := strings.ContainsRune(string(emoji), '‍')
+	var points []string
+	for _, r := range emoji {
+		if r == '️' && !zwj {
+			continue
+		}
+		points = append(points, strconv.FormatInt(int64(r), 16))
+	}
+	return strings.Join(points, "-") + ".svg"
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// fakeTwemoji embeds an SVG for :rocket: only until the test ends.
+func fakeTwemoji(t *testing.T) {
+	t.Helper()
+	files := twemojiFiles
+	t.Cleanup(func() { twemojiFiles = files })
+	twemojiFiles = fstest.MapFS{
+		twemojiDir + "/1f680.svg": {Data: []byte("<svg/>")},
+	}
+}
+
+func TestEmoji(t *testing.T) {
+	const src = "Launch :rocket:, :tada: and :notanemoji:, not `:rocket:`.\n"
+	const rocketData = `<img class="emoji" alt="🚀" title=":rocket:" src="data:image/svg+xml;base64,PHN2Zy8+"/>`
+	tests := []struct {
+		name          string
+		opts          Options
+		svg           bool
+		want, notWant []string
+	}{
+		{"unicode", Options{}, false,
+			[]string{"Launch 🚀, 🎉 and :notanemoji:, not <code>:rocket:</code>"}, []string{"<img"}},
+		{"twemoji", Options{EmojiStyle: EmojiTwemoji}, true,
+			[]string{"Launch " + rocketData + ", 🎉 and :notanemoji:", "<code>:rocket:</code>"}, []string{"cdn", "1f389"}},
+		{"twemoji without SVGs", Options{EmojiStyle: EmojiTwemoji}, false,
+			[]string{"Launch 🚀, 🎉 and :notanemoji:, not <code>:rocket:</code>"}, []string{"<img", "cdn"}},
+		{"no emoji", Options{NoEmoji: true}, false,
+			[]string{"Launch :rocket:, :tada: and :notanemoji:"}, []string{"🚀", "<img"}},
+	}
+	for _, engine := range []string{EngineGFM, EngineGoldmark} {
+		for _, tt := range tests {
+			t.Run(engine+"/"+tt.name, func(t *testing.T) {
+				if tt.svg {
+					fakeTwemoji(t)
+				} else {
+					files := twemojiFiles
+					t.Cleanup(func() { twemojiFiles = files })
+					twemojiFiles = fstest.MapFS{}
+				}
+				opts := tt.opts
+				opts.Engine = engine
+				s := newTestServer(t, "doc.md", src, opts)
+				rendered, err := s.Render()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, want := range tt.want {
+					if !strings.Contains(string(rendered), want) {
+						t.Errorf("render lacks %s:\n%s", want, rendered)
+					}
+				}
+				for _, notWant := range tt.notWant {
+					if strings.Contains(string(rendered), notWant) {
+						t.Errorf("render has %s:\n%s", notWant, rendered)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEmojiInURLs(t *testing.T) {
+	s := newTestServer(t, "doc.md", "", Options{})
+	for in, want := range map[string]string{
+		"<p>https://host/x :tada:</p>":                                 "<p>https://host/x 🎉</p>",
+		"<p>see https://host/a:smile:b :tada:</p>":                     "<p>see https://host/a:smile:b 🎉</p>",
+		"<p>www.host/:smile: :tada:</p>":                               "<p>www.host/:smile: 🎉</p>",
+		`<p><a href="https://host/:tada:">https://host/:tada:</a></p>`: `<p><a href="https://host/:tada:">https://host/:tada:</a></p>`,
+	} {
+		got, err := postProcess([]byte(in), s.emoji(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("emoji in %s = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestLiveTwemoji(t *testing.T) {
+	fakeTwemoji(t)
+	s := newTestServer(t, "doc.md", "Launch :rocket:\n", Options{EmojiStyle: EmojiTwemoji})
+	ts := startTestServer(t, s)
+	ws := dialTestServer(t, ts, "")
+	msg := readConnected(t, ws)
+	// Served pages load the SVG rather than carrying it.
+	if !strings.Contains(msg.HTML, `src="/twemoji/1f680.svg"`) {
+		t.Errorf("live render doesn't point at the embedded SVG:\n%s", msg.HTML)
+	}
+}
+
+func TestTwemojiName(t *testing.T) {
+	tests := []struct {
+		emoji, want string
+	}{
+		{"🚀", "1f680.svg"},
+		// A lone variation selector is left out of the name...
+		{"❤️", "2764.svg"},
+		// ...but kept in zero width joiner sequences.
+		{"🏳️‍🌈", "1f3f3-fe0f-200d-1f308.svg"},
+	}
+	for _, tt := range tests {
+		if got := twemojiName([]rune(tt.emoji)); got != tt.want {
+			t.Errorf("twemojiName(%q) = %s, want %s", tt.emoji, got, tt.want)
+		}
+	}
+	// The embedded SVGs, when fetched, follow the same naming.
+	if _, err := fs.Stat(staticFiles, twemojiDir); err == nil {
+		if _, err := fs.Stat(staticFiles, twemojiDir+"/1f680.svg"); err != nil {
+			t.Errorf("embedded Twemoji lack :rocket:: %v", err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/shurcooL/github_flavored_markdown"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// Markdown engines accepted by Options.Engine for local renders.
+const (
+	// EngineGFM renders with github_flavored_markdown, built on
+	// blackfriday.
+	EngineGFM = "gfm"
+	// EngineGoldmark renders CommonMark with goldmark and its GitHub
+	// extensions, footnotes and definition lists.
+	EngineGoldmark = "goldmark"
+)
+
+func validEngine(engine string) error {
+	switch engine {
+	case EngineGFM, EngineGoldmark:
+		return nil
+	}
+	return fmt.Errorf("unknown Markdown engine %q, expected %s or %s", engine, EngineGFM, EngineGoldmark)
+}
+
+// Renderer converts Markdown to an HTML fragment.
+type Renderer interface {
+	Render(input []byte) ([]byte, error)
+}
+
+// newEngine returns the local renderer for engine.
+func newEngine(engine string) Renderer {
+	if engine == EngineGoldmark {
+		return newGoldmarkRenderer()
+	}
+	return gfmRenderer{}
+}
+
+type gfmRenderer struct{}
+
+func (gfmRenderer) Render(input []byte) ([]byte, error) {
+	return github_flavored_markdown.Markdown(input), nil
+}
+
+type goldmarkRenderer struct {
+	md goldmark.Markdown
+}
+
+func newGoldmarkRenderer() goldmarkRenderer {
+	return goldmarkRenderer{goldmark.New(
+		goldmark.WithExtensions(
+			// Alignment as an attribute survives sanitizing, unlike a
+			// style.
+			extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+			extension.Strikethrough,
+			extension.Linkify,
+			extension.TaskList,
+			extension.Footnote,
+			extension.DefinitionList,
+		),
+		// Raw HTML is let through to the policy, as with gfm, rather than
+		// dropped.
+		goldmark.WithRendererOptions(gmhtml.WithUnsafe()),
+	)}
+}
+
+func (r goldmarkRenderer) Render(input []byte) ([]byte, error) {
+	var out bytes.Buffer
+	if err := r.md.Convert(input, &out); err != nil {
+		return nil, err
+	}
+	return goldmarkPolicy.SanitizeBytes(out.Bytes()), nil
+}
+
+// goldmarkPolicy sanitizes goldmark renders like github_flavored_markdown
+// does its own, keeping the markup of code languages, task lists and
+// footnotes.
+var goldmarkPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#.-]+$`)).OnElements("code")
+	p.AllowAttrs("class").Matching(bluemonday.SpaceSeparatedTokens).OnElements("a", "div", "span")
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-(noteref|endnotes|backlink)$`)).OnElements("a", "div")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^$`)).OnElements("input")
+	p.AllowDataURIImages()
+	return p
+}()
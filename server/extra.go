@@ -0,0 +1,39 @@
+package server
+
+import (
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// addExtraWatches adds the extra files to w. A missing one is only logged,
+// as the document may still be worth previewing without it.
+func (s *Server) addExtraWatches(w *fsnotify.Watcher) {
+	for p := range s.watchExtra {
+		if err := w.Add(p); err != nil {
+			s.log.WithError(err).WithField("file", p).Warn("failed to watch extra file")
+		}
+	}
+}
+
+// extraEvent handles an event on one of the extra files by re-rendering
+// every open document, reporting false for events on any other file.
+func (s *Server) extraEvent(w *fsnotify.Watcher, event fsnotify.Event) bool {
+	if !s.watchExtra[event.Name] {
+		return false
+	}
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		// Re-add it once an editor's rename into place is done, as for the
+		// document itself.
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			if err := w.Add(event.Name); err != nil {
+				s.log.WithError(err).Debug("failed to re-add watch")
+			}
+		}()
+	}
+	// The documents' own content is unchanged, so their cached renders
+	// have to go.
+	s.reloadAll()
+	return true
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Formats accepted by Options.Format.
+const (
+	FormatAuto     = "auto"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+// DetectFormat picks the format of the file at path from its extension.
+func DetectFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		return FormatHTML
+	}
+	return FormatMarkdown
+}
+
+// resolveFormat validates format, replacing FormatAuto with the format
+// detected for path.
+func resolveFormat(format, path string) (string, error) {
+	switch format {
+	case "", FormatAuto:
+		return DetectFormat(path), nil
+	case FormatMarkdown, FormatHTML:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown format %q, expected %s, %s or %s", format, FormatAuto, FormatMarkdown, FormatHTML)
+}
+
+// passThroughHTML serves an HTML file as is, or with unsafe markup such as
+// scripts removed when sanitizing.
+func (s *Server) passThroughHTML(input []byte) []byte {
+	if !s.sanitizeHTML {
+		return input
+	}
+	return bluemonday.UGCPolicy().SanitizeBytes(input)
+}
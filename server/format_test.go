@@ -0,0 +1,68 @@
+package server
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	for path, want := range map[string]string{
+		"README.md":    FormatMarkdown,
+		"notes.txt":    FormatMarkdown,
+		"page.html":    FormatHTML,
+		"PAGE.HTM":     FormatHTML,
+		"dir/x.html":   FormatHTML,
+		"x.html.md":    FormatMarkdown,
+		"no-extension": FormatMarkdown,
+	} {
+		if got := DetectFormat(path); got != want {
+			t.Errorf("DetectFormat(%q) = %q, want %q", path, got, want)
+		}
+	}
+	if got, err := resolveFormat(FormatMarkdown, "page.html"); err != nil || got != FormatMarkdown {
+		t.Errorf("explicit format overridden: %q, %v", got, err)
+	}
+	if _, err := resolveFormat("rst", "doc.rst"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestPreviewHTML(t *testing.T) {
+	s := newTestServer(t, "page.html", "<h1>Page</h1>\n<p>*not Markdown*</p>\n", Options{})
+	ws := dialTestServer(t, startTestServer(t, s), "")
+
+	msg := readType(t, ws, "render")
+	if !strings.Contains(msg.HTML, "<h1>Page</h1>") || !strings.Contains(msg.HTML, "*not Markdown*") {
+		t.Errorf("HTML file rendered as %q, want it as is", msg.HTML)
+	}
+
+	if err := os.WriteFile(s.path, []byte("<h1>Edited</h1>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if msg := waitForRender(t, ws, "Edited"); strings.Contains(msg.HTML, "Page") {
+		t.Errorf("reload kept the old content: %q", msg.HTML)
+	}
+}
+
+func TestPreviewHTMLSanitized(t *testing.T) {
+	s := newTestServer(t, "page.html", "<h1>Page</h1><script>alert(1)</script>\n", Options{SanitizeHTML: true})
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rendered), "<h1>Page</h1>") || strings.Contains(string(rendered), "<script>") {
+		t.Errorf("sanitized HTML = %q", rendered)
+	}
+}
+
+func TestPreviewMarkdownUnaffected(t *testing.T) {
+	s := newTestServer(t, "doc.md", "<b>inline</b> and *emphasis*\n", Options{})
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rendered), "<em>emphasis</em>") {
+		t.Errorf("Markdown rendered as %q", rendered)
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatter splits YAML front matter off the start of Markdown input: a
+// "---" line, the YAML, and a closing "---" or "..." line. The body keeps
+// the front matter's lines, blanked, so that line numbers still match the
+// file. ok is false if there is no front matter or it is disabled.
+func (s *Server) frontMatter(input []byte) (body, front []byte, ok bool) {
+	if s.format != FormatMarkdown || s.noFrontMatter {
+		return input, nil, false
+	}
+	lines := bytes.SplitAfter(input, []byte("\n"))
+	if len(lines) == 0 || string(bytes.TrimRight(lines[0], "\r\n")) != "---" {
+		return input, nil, false
+	}
+	for i := 1; i < len(lines); i++ {
+		switch string(bytes.TrimRight(lines[i], " \t\r\n")) {
+		case "---", "...":
+			front = bytes.Join(lines[1:i], nil)
+			body = append(bytes.Repeat([]byte("\n"), i+1), bytes.Join(lines[i+1:], nil)...)
+			return body, front, true
+		}
+	}
+	return input, nil, false
+}
+
+// frontMatterTable returns a transform that shows front matter at the top of
+// the render as a table of its keys and values. Front matter that isn't a
+// YAML mapping is shown verbatim instead.
+func frontMatterTable(front []byte) transform {
+	return func(root *html.Node) {
+		var block *html.Node
+		mapping := frontMatterMapping(front)
+		if mapping == nil {
+			if len(bytes.TrimSpace(front)) == 0 {
+				return
+			}
+			block = element("pre", atom.Pre, "front-matter")
+			block.AppendChild(&html.Node{Type: html.TextNode, Data: "---\n" + string(front) + "---"})
+		} else {
+			block = element("table", atom.Table, "front-matter")
+			tbody := element("tbody", atom.Tbody, "")
+			block.AppendChild(tbody)
+			pairs := mapping.Content
+			for i := 0; i+1 < len(pairs); i += 2 {
+				tr := element("tr", atom.Tr, "")
+				th := element("th", atom.Th, "")
+				th.AppendChild(&html.Node{Type: html.TextNode, Data: pairs[i].Value})
+				td := element("td", atom.Td, "")
+				td.AppendChild(frontMatterValue(pairs[i+1]))
+				tr.AppendChild(th)
+				tr.AppendChild(td)
+				tbody.AppendChild(tr)
+			}
+		}
+		block.Attr = append(block.Attr, html.Attribute{Key: "data-source-line", Val: "1"})
+		root.InsertBefore(block, root.FirstChild)
+	}
+}
+
+// frontMatterMapping parses front matter, returning nil unless it is a YAML
+// mapping.
+func frontMatterMapping(front []byte) *yaml.Node {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(front, &doc); err != nil || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	return doc.Content[0]
+}
+
+// frontMatterValue renders a value: scalars as text, lists of scalars joined
+// with commas and anything else as YAML.
+func frontMatterValue(v *yaml.Node) *html.Node {
+	switch v.Kind {
+	case yaml.ScalarNode:
+		return &html.Node{Type: html.TextNode, Data: v.Value}
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(v.Content))
+		for _, item := range v.Content {
+			if item.Kind != yaml.ScalarNode {
+				items = nil
+				break
+			}
+			items = append(items, item.Value)
+		}
+		if items != nil {
+			return &html.Node{Type: html.TextNode, Data: strings.Join(items, ", ")}
+		}
+	}
+	out, _ := yaml.Marshal(v)
+	code := element("code", atom.Code, "")
+	code.AppendChild(&html.Node{Type: html.TextNode, Data: strings.TrimSpace(string(out))})
+	return code
+}
+
+// element creates an element, with a class unless class is empty.
+func element(tag string, a atom.Atom, class string) *html.Node {
+	n := &html.Node{Type: html.ElementNode, Data: tag, DataAtom: a}
+	if class != "" {
+		n.Attr = []html.Attribute{{Key: "class", Val: class}}
+	}
+	return n
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// githubMarkdownURL is the GitHub API endpoint rendering raw Markdown.
+var githubMarkdownURL = "https://api.github.com/markdown/raw"
+
+// githubRetries and githubBackoff bound how often an API render retries
+// transient failures, and githubTimeout how long it may take in all,
+// retries included, before falling back to local rendering.
+var (
+	githubRetries = 2
+	githubBackoff = 250 * time.Millisecond
+	githubTimeout = 10 * time.Second
+)
+
+// statusError is a non-2xx response from the GitHub API.
+type statusError struct {
+	status string
+	code   int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("GitHub API: %s: %s", e.status, e.body)
+}
+
+// rateLimitError reports that the GitHub API refused a render because the
+// rate limit was exhausted.
+type rateLimitError struct {
+	reset time.Time
+	token bool
+}
+
+func (e *rateLimitError) Error() string {
+	msg := "GitHub API rate limit exceeded"
+	if !e.reset.IsZero() {
+		msg += fmt.Sprintf("; it resets at %s", e.reset.Format("15:04:05"))
+	}
+	if !e.token {
+		msg += "; set -token or GITHUB_TOKEN to raise the limit"
+	}
+	return msg
+}
+
+// renderGitHub renders input with the GitHub Markdown API, authenticating
+// with the configured token if there is one.
+func (s *Server) renderGitHub(ctx context.Context, input []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", githubMarkdownURL, bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	if s.githubToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.githubToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return body, nil
+	}
+	if isRateLimited(resp) {
+		e := &rateLimitError{token: s.githubToken != ""}
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			e.reset = time.Unix(reset, 0)
+		}
+		return nil, e
+	}
+	return nil, &statusError{status: resp.Status, code: resp.StatusCode, body: strings.TrimSpace(string(body))}
+}
+
+// renderGitHubRetry calls renderGitHub, retrying network errors and server
+// errors with backoff until githubTimeout has passed.
+func (s *Server) renderGitHubRetry(input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, githubTimeout)
+	defer cancel()
+
+	backoff := githubBackoff
+	for attempt := 0; ; attempt++ {
+		rendered, err := s.renderGitHub(ctx, input)
+		if err == nil || attempt == githubRetries || !retryable(err) || ctx.Err() != nil {
+			return rendered, err
+		}
+		s.log.WithError(err).Debugf("retrying GitHub API render in %s", backoff)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryable reports whether a failed API render might succeed if repeated.
+// Client errors, including rate limiting, will not.
+func retryable(err error) bool {
+	var rateLimited *rateLimitError
+	if errors.As(err, &rateLimited) {
+		return false
+	}
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code >= 500
+	}
+	return true
+}
+
+// isRateLimited reports whether resp is GitHub refusing a request for
+// exceeding the primary rate limit.
+func isRateLimited(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusForbidden, http.StatusTooManyRequests:
+		return resp.Header.Get("X-RateLimit-Remaining") == "0"
+	}
+	return false
+}
+
+// githubRenderer renders with the GitHub API, falling back to local
+// rendering with fallback when the API fails.
+type githubRenderer struct {
+	s        *Server
+	fallback Renderer
+}
+
+func (r githubRenderer) Render(input []byte) ([]byte, error) {
+	rendered, _, err := r.render(input)
+	return rendered, err
+}
+
+// render renders input as Render does, reporting whether it fell back to
+// local rendering, whose output needs the rewrites GitHub would have made.
+func (r githubRenderer) render(input []byte) ([]byte, bool, error) {
+	rendered, err := r.s.renderGitHubRetry(input)
+	var rateLimited *rateLimitError
+	if err == nil || errors.As(err, &rateLimited) || r.s.ctx.Err() != nil {
+		// Rate limiting is reported to the viewer, who can fix it with a
+		// token, rather than silently degrading every render.
+		return rendered, false, err
+	}
+	r.s.log.WithError(err).Warn("GitHub API render failed, falling back to local rendering")
+	rendered, err = r.fallback.Render(input)
+	return rendered, true, err
+}
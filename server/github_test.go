@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeGitHub points API renders at handler until the test ends, with short
+// backoff and timeout.
+func fakeGitHub(t *testing.T, timeout time.Duration, handler http.HandlerFunc) {
+	t.Helper()
+	api := httptest.NewServer(handler)
+	t.Cleanup(api.Close)
+	url, backoff, limit := githubMarkdownURL, githubBackoff, githubTimeout
+	t.Cleanup(func() { githubMarkdownURL, githubBackoff, githubTimeout = url, backoff, limit })
+	githubMarkdownURL, githubBackoff, githubTimeout = api.URL, 10*time.Millisecond, timeout
+}
+
+// newAPITestServer returns a Server rendering doc.md, holding content, with
+// the GitHub API.
+func newAPITestServer(t *testing.T, content string, opts Options) *Server {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "doc.md")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s, err := New(ctx, []string{path}, testLogger(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestGitHubRender(t *testing.T) {
+	fakeGitHub(t, time.Second, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		w.Write([]byte("<p>from the API</p>"))
+	})
+	s := newAPITestServer(t, "text\n", Options{GitHubToken: "secret"})
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rendered), "from the API") {
+		t.Errorf("render = %s, want the API's", rendered)
+	}
+}
+
+func TestGitHubRenderRetriesThenFallsBack(t *testing.T) {
+	var calls atomic.Int32
+	fakeGitHub(t, time.Second, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	s := newAPITestServer(t, "*local*\n", Options{})
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rendered), "<em>local</em>") {
+		t.Errorf("render = %s, want the local fallback", rendered)
+	}
+	if got, want := calls.Load(), int32(githubRetries+1); got != want {
+		t.Errorf("API called %d times, want %d", got, want)
+	}
+}
+
+func TestGitHubFallbackTransforms(t *testing.T) {
+	fakeGitHub(t, time.Second, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	s := newAPITestServer(t, ":smile:\n\n```go\nfunc main() {}\n```\n", Options{})
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fallback gets what GitHub would have done to the render.
+	out := string(rendered)
+	if !strings.Contains(out, `<span style="color:`) {
+		t.Errorf("code block not highlighted in the local fallback:\n%s", out)
+	}
+	if strings.Contains(out, ":smile:") {
+		t.Errorf("emoji shortcode left in the local fallback:\n%s", out)
+	}
+}
+
+func TestGitHubRenderDeadline(t *testing.T) {
+	fakeGitHub(t, 200*time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the client hanging up once the body is
+		// read.
+		io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	s := newAPITestServer(t, "*local*\n", Options{})
+	start := time.Now()
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("fell back after %s, past the 200ms deadline", took)
+	}
+	if !strings.Contains(string(rendered), "<em>local</em>") {
+		t.Errorf("render = %s, want the local fallback", rendered)
+	}
+}
+
+func TestGitHubRateLimit(t *testing.T) {
+	fakeGitHub(t, time.Second, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		http.Error(w, "rate limited", http.StatusForbidden)
+	})
+	s := newAPITestServer(t, "text\n", Options{})
+	_, err := s.Render()
+	var rateLimited *rateLimitError
+	if !errors.As(err, &rateLimited) {
+		t.Fatalf("error = %v, want rate limiting reported", err)
+	}
+	if !strings.Contains(err.Error(), "-token") {
+		t.Errorf("error %q doesn't suggest a token", err)
+	}
+}
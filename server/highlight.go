@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// DefaultCodeTheme is the Chroma style used when Options.CodeTheme is unset.
+const DefaultCodeTheme = "github"
+
+func validCodeTheme(name string) error {
+	if _, ok := styles.Registry[name]; ok {
+		return nil
+	}
+	names := make([]string, 0, len(styles.Registry))
+	for n := range styles.Registry {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown code theme %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// highlightCode returns a transform that highlights fenced code blocks with
+// the named Chroma style. Styles are inlined so that the output needs no
+// extra stylesheet. Blocks without a language, or with one Chroma doesn't
+// know, are left alone rather than guessed at.
+func highlightCode(theme string) transform {
+	style := styles.Get(theme)
+	formatter := chromahtml.New(chromahtml.WithClasses(false))
+	return func(root *html.Node) {
+		walk(root, func(n *html.Node) bool {
+			lang, pre, ok := codeBlock(n)
+			if !ok {
+				return true
+			}
+			if lexer := lexers.Get(lang); lang != "" && lexer != nil {
+				if highlighted := highlight(lexer, formatter, style, textContent(pre)); highlighted != nil {
+					// A bare pre, as goldmark renders, is itself the block
+					// tied to a source line, and keeps its language for
+					// later transforms.
+					if line, ok := attr(pre, "data-source-line"); ok {
+						setAttr(highlighted, "data-source-line", line)
+					}
+					if pre == n {
+						setAttr(highlighted, "lang", lang)
+					}
+					pre.Parent.InsertBefore(highlighted, pre)
+					pre.Parent.RemoveChild(pre)
+				}
+			}
+			return false
+		})
+	}
+}
+
+// highlight formats code as a <pre> element, or returns nil if it fails.
+func highlight(lexer chroma.Lexer, formatter *chromahtml.Formatter, style *chroma.Style, code string) *html.Node {
+	it, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := formatter.Format(&buf, style, it); err != nil {
+		return nil
+	}
+	nodes, err := html.ParseFragment(&buf, &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div})
+	if err != nil {
+		return nil
+	}
+	for _, n := range nodes {
+		if n.DataAtom == atom.Pre {
+			return n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlightCode(t *testing.T) {
+	for _, engine := range []string{EngineGFM, EngineGoldmark} {
+		s := newTestServer(t, "doc.md", "# Code\n\n```go\nfunc main() {}\n```\n\n```\nplain\n```\n", Options{Engine: engine})
+		rendered, err := s.Render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := string(rendered)
+		if !strings.Contains(out, `<span style="color:`) {
+			t.Errorf("%s: Go block not highlighted:\n%s", engine, out)
+		}
+		// Later transforms still find the block and its language.
+		if !strings.Contains(out, `data-source-line="3"`) || !strings.Contains(out, `lang="go"`) && !strings.Contains(out, "highlight-go") {
+			t.Errorf("%s: highlighted block lost its source line or language:\n%s", engine, out)
+		}
+		if !strings.Contains(out, "plain") {
+			t.Errorf("%s: block without a language dropped:\n%s", engine, out)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// transform rewrites rendered HTML in place. root is a synthetic container
+// whose children are the top-level rendered nodes.
+type transform func(root *html.Node)
+
+// postProcess applies transforms to rendered HTML, parsing and serializing
+// it only once however many transforms there are.
+func postProcess(rendered []byte, transforms ...transform) ([]byte, error) {
+	if len(transforms) == 0 {
+		return rendered, nil
+	}
+
+	root := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(bytes.NewReader(rendered), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+
+	for _, t := range transforms {
+		t(root)
+	}
+
+	var buf bytes.Buffer
+	for n := root.FirstChild; n != nil; n = n.NextSibling {
+		if err := html.Render(&buf, n); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// walk calls fn on n and its descendants in document order. Returning false
+// from fn skips the node's descendants.
+func walk(n *html.Node, fn func(*html.Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; {
+		// fn may detach c, so find its sibling first.
+		next := c.NextSibling
+		walk(c, fn)
+		c = next
+	}
+}
+
+// attr returns the value of the named attribute of n.
+func attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// setAttr sets the named attribute of n, adding it if necessary.
+func setAttr(n *html.Node, key, val string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+// textContent returns the concatenated text of n and its descendants.
+func textContent(n *html.Node) string {
+	var buf bytes.Buffer
+	walk(n, func(c *html.Node) bool {
+		if c.Type == html.TextNode {
+			buf.WriteString(c.Data)
+		}
+		return true
+	})
+	return buf.String()
+}
+
+// codeBlock reports whether n is a fenced code block, returning the block's
+// language and the pre element holding its code. Local renders wrap tagged
+// blocks in <div class="highlight highlight-LANG">; the GitHub API uses
+// highlight-source-LANG. Untagged blocks are a bare <pre>.
+func codeBlock(n *html.Node) (lang string, pre *html.Node, ok bool) {
+	if n.Type != html.ElementNode {
+		return "", nil, false
+	}
+	switch n.DataAtom {
+	case atom.Div:
+		class, _ := attr(n, "class")
+		for _, c := range strings.Fields(class) {
+			if l := strings.TrimPrefix(c, "highlight-"); l != c {
+				lang = strings.TrimPrefix(l, "source-")
+			}
+		}
+		if lang == "" {
+			return "", nil, false
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.Pre {
+				return lang, c, true
+			}
+		}
+	case atom.Pre:
+		lang, _ = attr(n, "lang")
+		if code := n.FirstChild; lang == "" && code != nil && code.DataAtom == atom.Code {
+			class, _ := attr(code, "class")
+			for _, c := range strings.Fields(class) {
+				if l := strings.TrimPrefix(c, "language-"); l != c {
+					lang = l
+				}
+			}
+		}
+		return lang, n, true
+	}
+	return "", nil, false
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Defaults for Options.PingInterval, Options.WSTimeout and
+// Options.WriteTimeout.
+const (
+	DefaultPingInterval = 2 * time.Second
+	DefaultWSTimeout    = 60 * time.Second
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+// message is a WebSocket message ready to be written to clients.
+type message struct {
+	typ  int
+	data []byte
+	// transient marks a message, such as an error, that doesn't replace
+	// the content on screen.
+	transient bool
+	// source marks a message carrying the file's content for editors. It
+	// isn't replayed, as clients are sent the content when they connect.
+	source bool
+	// skip is a client not to send the message to, as it saved the content.
+	skip *client
+}
+
+// client is a WebSocket connection subscribed to the hub.
+type client struct {
+	ws *websocket.Conn
+	// send receives broadcasts. The hub closes it on unregister.
+	send chan message
+	// readOnly marks a client that came through a share link and may not
+	// save.
+	readOnly bool
+	// writeWait bounds how long a single write may take.
+	writeWait time.Duration
+
+	// writeMu serializes writes, as the connection allows only one writer.
+	writeMu sync.Mutex
+}
+
+func newClient(ws *websocket.Conn, writeWait time.Duration) *client {
+	return &client{
+		ws:        ws,
+		send:      make(chan message, 8),
+		writeWait: writeWait,
+	}
+}
+
+// write sends a message to the client.
+func (c *client) write(typ int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.ws.SetWriteDeadline(time.Now().Add(c.writeWait)); err != nil {
+		return err
+	}
+	return c.ws.WriteMessage(typ, data)
+}
+
+// hub fans each render out to every connected client, so that a single
+// watcher and render serve any number of browser tabs.
+type hub struct {
+	register   chan *client
+	unregister chan *client
+	broadcast  chan message
+
+	clients map[*client]bool
+	// last is the latest content broadcast and lastTransient any transient
+	// one since. Both are replayed to clients when they register.
+	last          *message
+	lastTransient *message
+}
+
+func newHub() *hub {
+	return &hub{
+		register:   make(chan *client),
+		unregister: make(chan *client),
+		broadcast:  make(chan message),
+		clients:    make(map[*client]bool),
+	}
+}
+
+func (h *hub) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			for c := range h.clients {
+				h.drop(c)
+			}
+			return
+		case c := <-h.register:
+			h.clients[c] = true
+			if h.last != nil {
+				c.send <- *h.last
+			}
+			if h.lastTransient != nil {
+				c.send <- *h.lastTransient
+			}
+		case c := <-h.unregister:
+			if h.clients[c] {
+				h.drop(c)
+			}
+		case m := <-h.broadcast:
+			switch {
+			case m.source:
+			case m.transient:
+				h.lastTransient = &m
+			default:
+				h.last, h.lastTransient = &m, nil
+			}
+			for c := range h.clients {
+				if c == m.skip {
+					continue
+				}
+				select {
+				case c.send <- m:
+				default:
+					// The client isn't keeping up; disconnect it rather
+					// than stall everyone else.
+					h.drop(c)
+				}
+			}
+		}
+	}
+}
+
+func (h *hub) drop(c *client) {
+	delete(h.clients, c)
+	close(c.send)
+}
+
+// subscribe registers c, reporting false if the hub has shut down.
+func (h *hub) subscribe(ctx context.Context, c *client) bool {
+	select {
+	case h.register <- c:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// unsubscribe unregisters c. It is safe to call after the hub dropped c.
+func (h *hub) unsubscribe(ctx context.Context, c *client) {
+	select {
+	case h.unregister <- c:
+	case <-ctx.Done():
+	}
+}
+
+// publish broadcasts m to every client.
+func (h *hub) publish(ctx context.Context, m message) {
+	select {
+	case h.broadcast <- m:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io/fs"
+	"regexp"
+	"strings"
+)
+
+// katexDir holds KaTeX in the embedded static files, fetched by make katex.
+const katexDir = "static/katex"
+
+// katexEmbedded reports whether KaTeX was fetched into the static files
+// before building.
+func katexEmbedded() bool {
+	_, err := fs.Stat(staticFiles, katexDir+"/katex.min.js")
+	return err == nil
+}
+
+// katexFont matches a font in the src list of KaTeX's stylesheet, with its
+// file name, extension and the comma after it, if any.
+var katexFont = regexp.MustCompile(`url\(fonts/([^)]+)\.(woff2|woff|ttf)\) format\("[a-z0-9]+"\),?`)
+
+// inlineKaTeX returns the script and stylesheet of the KaTeX dist in katex,
+// for standalone pages, which can't load files. The woff2 fonts, which
+// every browser KaTeX supports reads, are inlined into the stylesheet as
+// data URLs and the other formats dropped.
+func inlineKaTeX(katex fs.FS) (js []byte, css string, err error) {
+	if js, err = fs.ReadFile(katex, "katex.min.js"); err != nil {
+		return nil, "", err
+	}
+	stylesheet, err := fs.ReadFile(katex, "katex.min.css")
+	if err != nil {
+		return nil, "", err
+	}
+	css = katexFont.ReplaceAllStringFunc(string(stylesheet), func(src string) string {
+		m := katexFont.FindStringSubmatch(src)
+		if err != nil || m[2] != "woff2" {
+			return ""
+		}
+		var font []byte
+		if font, err = fs.ReadFile(katex, "fonts/"+m[1]+".woff2"); err != nil {
+			return ""
+		}
+		return `url(data:font/woff2;base64,` + base64.StdEncoding.EncodeToString(font) + `) format("woff2")`
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return js, css, nil
+}
+
+// protectMath replaces $...$ and $$...$$ math in Markdown with placeholders,
+// so that the Markdown renderer doesn't take underscores and backslashes in
+// it for markup. Code spans and fenced code blocks are left alone, as is $
+// followed by a space, escaped as \$, or closed by a $ after a space or
+// before a digit, so that prices like $5 stay text. restoreMath puts the
+// math back into the render.
+func protectMath(src []byte) ([]byte, []string) {
+	var out bytes.Buffer
+	var spans []string
+	lines := strings.SplitAfter(string(src), "\n")
+	trimmed := make([]string, len(lines))
+	for i, line := range lines {
+		trimmed[i] = strings.TrimRight(line, "\r\n")
+	}
+	var text strings.Builder
+	flush := func() {
+		spans = protectMathText(&out, text.String(), spans)
+		text.Reset()
+	}
+	for i := 0; i < len(lines); {
+		if fenceOpen.MatchString(trimmed[i]) {
+			flush()
+			end := skipFence(trimmed, i)
+			out.WriteString(strings.Join(lines[i:end], ""))
+			i = end
+			continue
+		}
+		text.WriteString(lines[i])
+		i++
+	}
+	flush()
+	return out.Bytes(), spans
+}
+
+// protectMathText protects the math in text outside of code blocks, writing
+// the result to out and appending the math to spans.
+func protectMathText(out *bytes.Buffer, text string, spans []string) []string {
+	for i := 0; i < len(text); {
+		switch {
+		case text[i] == '\\' && i+1 < len(text):
+			out.WriteString(text[i : i+2])
+			i += 2
+		case text[i] == '`':
+			n := runLength(text[i:], '`')
+			fence := text[i : i+n]
+			end := strings.Index(text[i+n:], fence)
+			if end < 0 {
+				out.WriteString(fence)
+				i += n
+				continue
+			}
+			out.WriteString(text[i : i+n+end+n])
+			i += n + end + n
+		case strings.HasPrefix(text[i:], "$$"):
+			end := strings.Index(text[i+2:], "$$")
+			if end < 0 || strings.TrimSpace(text[i+2:i+2+end]) == "" {
+				out.WriteString("$$")
+				i += 2
+				continue
+			}
+			spans = append(spans, text[i:i+2+end+2])
+			out.WriteString(mathPlaceholder(len(spans) - 1))
+			i += 2 + end + 2
+		case text[i] == '$':
+			if end := inlineMathEnd(text, i); end > 0 {
+				spans = append(spans, text[i:end+1])
+				out.WriteString(mathPlaceholder(len(spans) - 1))
+				i = end + 1
+				continue
+			}
+			out.WriteByte('$')
+			i++
+		default:
+			out.WriteByte(text[i])
+			i++
+		}
+	}
+	return spans
+}
+
+// inlineMathEnd returns the index of the $ closing inline math opened at
+// start, or -1. Inline math doesn't span lines.
+func inlineMathEnd(text string, start int) int {
+	if start+1 >= len(text) || strings.ContainsRune(" \t\n", rune(text[start+1])) {
+		return -1
+	}
+	for j := start + 1; j < len(text); j++ {
+		switch text[j] {
+		case '\n':
+			return -1
+		case '\\':
+			j++
+		case '$':
+			if strings.ContainsRune(" \t", rune(text[j-1])) || (j+1 < len(text) && text[j+1] >= '0' && text[j+1] <= '9') {
+				continue
+			}
+			return j
+		}
+	}
+	return -1
+}
+
+func runLength(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+// mathPlaceholder stands in for math while rendering Markdown. It is plain
+// letters and digits, which no renderer changes.
+func mathPlaceholder(i int) string {
+	return fmt.Sprintf("MDPREVIEWMATH%dX", i)
+}
+
+// restoreMath replaces the placeholders left by protectMath in rendered HTML
+// with the math, escaped, in <span class="math inline"> or
+// <span class="math display"> elements for KaTeX to render. The delimiters
+// are kept so that the source shows as written without KaTeX.
+func restoreMath(rendered []byte, spans []string) []byte {
+	for i := range spans {
+		class := "math inline"
+		if strings.HasPrefix(spans[i], "$$") {
+			class = "math display"
+		}
+		span := fmt.Sprintf(`<span class="%s">%s</span>`, class, html.EscapeString(spans[i]))
+		rendered = bytes.ReplaceAll(rendered, []byte(mathPlaceholder(i)), []byte(span))
+	}
+	return rendered
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestInlineKaTeX(t *testing.T) {
+	katex := fstest.MapFS{
+		"katex.min.js":                   {Data: []byte("var katex={};")},
+		"katex.min.css":                  {Data: []byte(`@font-face{font-family:KaTeX_Main;src:url(fonts/KaTeX_Main-Regular.woff2) format("woff2"),url(fonts/KaTeX_Main-Regular.woff) format("woff"),url(fonts/KaTeX_Main-Regular.ttf) format("truetype")}.katex{font:normal 1.21em KaTeX_Main}`)},
+		"fonts/KaTeX_Main-Regular.woff2": {Data: []byte("woff2 font")},
+	}
+	js, css, err := inlineKaTeX(katex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(js) != "var katex={};" {
+		t.Errorf("script = %q", js)
+	}
+	want := `@font-face{font-family:KaTeX_Main;src:url(data:font/woff2;base64,` + base64.StdEncoding.EncodeToString([]byte("woff2 font")) + `) format("woff2")}.katex{font:normal 1.21em KaTeX_Main}`
+	if css != want {
+		t.Errorf("stylesheet =\n%s\nwant\n%s", css, want)
+	}
+
+	delete(katex, "fonts/KaTeX_Main-Regular.woff2")
+	if _, _, err := inlineKaTeX(katex); err == nil {
+		t.Error("no error for a missing font")
+	}
+}
+
+func TestInlineScript(t *testing.T) {
+	got := string(inlineScript([]byte(`s = "</script>" + '</SCRIPT'; r = /<\/script/;`)))
+	if want := `s = "<\/script>" + '<\/SCRIPT'; r = /<\/script/;`; got != want {
+		t.Errorf("inlineScript = %s, want %s", got, want)
+	}
+}
+
+func TestStaticPageMath(t *testing.T) {
+	s := newTestServer(t, "doc.md", "Euler: $e^{i\\pi} + 1 = 0$\n", Options{})
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rendered), `<span class="math inline">$e^{i\pi} + 1 = 0$</span>`) {
+		t.Fatalf("math not kept with its delimiters:\n%s", rendered)
+	}
+	var page bytes.Buffer
+	if err := s.WritePage(&page, rendered); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(page.String(), "cdn.jsdelivr.net") {
+		t.Error("page loads KaTeX from a CDN")
+	}
+	if got := strings.Contains(page.String(), "mdpreviewRenderMath"); got != katexEmbedded() {
+		t.Errorf("page carries the math script: %v, KaTeX embedded: %v", got, katexEmbedded())
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// renderMermaid turns mermaid code blocks into <div class="mermaid">
+// elements holding the diagram source, for the Mermaid runtime in the
+// browser to draw.
+func renderMermaid(root *html.Node) {
+	walk(root, func(n *html.Node) bool {
+		lang, pre, ok := codeBlock(n)
+		if !ok {
+			return true
+		}
+		if lang != "mermaid" {
+			return false
+		}
+		src := textContent(pre)
+		attrs := []html.Attribute{{Key: "class", Val: "mermaid"}}
+		if line, ok := attr(n, "data-source-line"); ok {
+			attrs = append(attrs, html.Attribute{Key: "data-source-line", Val: line})
+		}
+		n.Type, n.Data, n.DataAtom, n.Attr = html.ElementNode, "div", atom.Div, attrs
+		for c := n.FirstChild; c != nil; c = n.FirstChild {
+			n.RemoveChild(c)
+		}
+		n.AppendChild(&html.Node{Type: html.TextNode, Data: src})
+		return false
+	})
+}
+
+// mermaidCLI is the Mermaid command line renderer, from @mermaid-js/mermaid-cli,
+// used to draw diagrams for static output.
+const mermaidCLI = "mmdc"
+
+// mermaidFile is the Mermaid runtime in the embedded static files, fetched
+// by make mermaid.
+const mermaidFile = "static/mermaid.min.js"
+
+// mermaidEmbedded reports whether the Mermaid runtime was fetched into the
+// static files before building.
+func mermaidEmbedded() bool {
+	_, err := fs.Stat(staticFiles, mermaidFile)
+	return err == nil
+}
+
+// mermaidCLIPath returns where mmdc is installed, or "" if it isn't. It is
+// looked up once, rather than on every render.
+func (s *Server) mermaidCLIPath() string {
+	s.mermaidOnce.Do(func() {
+		s.mermaidCLI, _ = exec.LookPath(mermaidCLI)
+	})
+	return s.mermaidCLI
+}
+
+// drawMermaid returns a transform that replaces the diagram sources left by
+// renderMermaid with SVG drawn by mmdc, so that static pages show diagrams
+// without JavaScript. Diagrams that can't be drawn, all of them when mmdc
+// isn't installed, are left for the embedded runtime, or as source without
+// it.
+func (s *Server) drawMermaid() transform {
+	return func(root *html.Node) {
+		cli := s.mermaidCLIPath()
+		if cli == "" {
+			return
+		}
+		walk(root, func(n *html.Node) bool {
+			if class, _ := attr(n, "class"); n.Type != html.ElementNode || n.Data != "div" || class != "mermaid" {
+				return true
+			}
+			svg, err := s.runMermaidCLI(cli, textContent(n))
+			if err != nil {
+				s.log.WithError(err).Warn("failed to draw Mermaid diagram")
+				return false
+			}
+			nodes, err := html.ParseFragment(bytes.NewReader(svg), &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div})
+			if err != nil {
+				s.log.WithError(err).Warn("failed to parse Mermaid diagram")
+				return false
+			}
+			for c := n.FirstChild; c != nil; c = n.FirstChild {
+				n.RemoveChild(c)
+			}
+			for _, c := range nodes {
+				n.AppendChild(c)
+			}
+			setAttr(n, "class", "mermaid-diagram")
+			return false
+		})
+	}
+}
+
+// runMermaidCLI draws a diagram with mmdc, returning the SVG.
+func (s *Server) runMermaidCLI(cli, src string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "mdpreview-mermaid")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in, out := filepath.Join(dir, "diagram.mmd"), filepath.Join(dir, "diagram.svg")
+	if err := os.WriteFile(in, []byte(src), 0644); err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(s.ctx, cli, "-q", "-b", "transparent", "-i", in, "-o", out)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", mermaidCLI, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return os.ReadFile(out)
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const diagramDoc = "# Flow\n\n```mermaid\ngraph TD; A-->B\n```\n"
+
+// fakeMermaidCLI puts an mmdc on PATH, until the test ends, that draws every
+// diagram as the same SVG.
+func fakeMermaidCLI(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake mmdc is a shell script")
+	}
+	dir := t.TempDir()
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+	if [ "$1" = -o ]; then out=$2; fi
+	shift
+done
+printf '<svg xmlns="http://www.w3.org/2000/svg"><text>drawn</text></svg>' > "$out"
+`
+	if err := os.WriteFile(filepath.Join(dir, mermaidCLI), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+}
+
+func TestExportDrawsMermaid(t *testing.T) {
+	fakeMermaidCLI(t)
+	s := newTestServer(t, "doc.md", diagramDoc, Options{})
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rendered), `<div class="mermaid-diagram"`) || !strings.Contains(string(rendered), "<svg") {
+		t.Errorf("export doesn't hold the drawn diagram:\n%s", rendered)
+	}
+	if strings.Contains(string(rendered), "graph TD") || strings.Contains(string(rendered), "<code") {
+		t.Errorf("export still holds the diagram source:\n%s", rendered)
+	}
+
+	// mmdc is looked up once.
+	t.Setenv("PATH", t.TempDir())
+	if rendered, err := s.Render(); err != nil || !strings.Contains(string(rendered), "<svg") {
+		t.Errorf("second export didn't draw the diagram: %v\n%s", err, rendered)
+	}
+}
+
+func TestLiveMermaidLeftToBrowser(t *testing.T) {
+	fakeMermaidCLI(t)
+	s := newTestServer(t, "doc.md", diagramDoc, Options{})
+	ws := dialTestServer(t, startTestServer(t, s), "")
+	msg := readConnected(t, ws)
+	if !strings.Contains(msg.HTML, `<div class="mermaid"`) || !strings.Contains(msg.HTML, "graph TD; A--&gt;B") {
+		t.Errorf("live render doesn't leave the diagram source to the browser:\n%s", msg.HTML)
+	}
+}
+
+func TestExportWithoutMermaidCLI(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	s := newTestServer(t, "doc.md", diagramDoc, Options{})
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rendered), `<div class="mermaid"`) {
+		t.Errorf("diagram source not kept without mmdc:\n%s", rendered)
+	}
+	var page bytes.Buffer
+	if err := s.WritePage(&page, rendered); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(page.String(), "cdn.jsdelivr.net") {
+		t.Error("page loads Mermaid from a CDN")
+	}
+	if got := strings.Contains(page.String(), "mermaid.initialize"); got != mermaidEmbedded() {
+		t.Errorf("page carries the runtime: %v, embedded: %v", got, mermaidEmbedded())
+	}
+}
+
+func TestCheckSkipsMermaidCLI(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake mmdc is a shell script")
+	}
+	dir := t.TempDir()
+	ran := filepath.Join(dir, "ran")
+	script := "#!/bin/sh\n: > '" + ran + "'\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(dir, mermaidCLI), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+	s := newTestServer(t, "doc.md", diagramDoc, Options{})
+	if _, err := s.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ran); err == nil {
+		t.Error("check ran mmdc")
+	}
+}
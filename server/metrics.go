@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// metrics counts renders and WebSocket writes since startup, for /metrics.
+type metrics struct {
+	mu            sync.Mutex
+	renders       int64
+	renderErrors  int64
+	cacheHits     int64
+	readTime      time.Duration
+	renderTime    time.Duration
+	renderedBytes int64
+	writes        int64
+	writeTime     time.Duration
+	writtenBytes  int64
+}
+
+// rendered counts a render that took read to read the file and render to
+// turn it into size bytes of HTML.
+func (m *metrics) rendered(read, render time.Duration, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.renders++
+	m.readTime += read
+	m.renderTime += render
+	m.renderedBytes += int64(size)
+}
+
+func (m *metrics) renderFailed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.renderErrors++
+}
+
+func (m *metrics) cacheHit() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cacheHits++
+}
+
+// wrote counts a WebSocket message of size bytes that took took to write.
+func (m *metrics) wrote(took time.Duration, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writes++
+	m.writeTime += took
+	m.writtenBytes += int64(size)
+}
+
+// handleMetrics serves the counters in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	m := &s.metrics
+	m.mu.Lock()
+	counters := []struct {
+		name, help string
+		value      interface{}
+	}{
+		{"mdpreview_renders_total", "Renders of a document.", m.renders},
+		{"mdpreview_render_errors_total", "Renders that failed.", m.renderErrors},
+		{"mdpreview_render_cache_hits_total", "Live renders of unchanged content served from the cache.", m.cacheHits},
+		{"mdpreview_read_seconds_total", "Time spent reading documents for renders.", m.readTime.Seconds()},
+		{"mdpreview_render_seconds_total", "Time spent rendering documents, after reading them.", m.renderTime.Seconds()},
+		{"mdpreview_rendered_bytes_total", "Size of the HTML rendered.", m.renderedBytes},
+		{"mdpreview_ws_writes_total", "Updates written to WebSocket clients.", m.writes},
+		{"mdpreview_ws_write_seconds_total", "Time spent writing updates to WebSocket clients.", m.writeTime.Seconds()},
+		{"mdpreview_ws_written_bytes_total", "Size of the updates written to WebSocket clients.", m.writtenBytes},
+	}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %v\n", c.name, c.help, c.name, c.name, c.value)
+	}
+}
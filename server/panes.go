@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// paneFiles checks the paths given to New, returning them cleaned, or nil
+// for a lone directory. Several paths must all be distinct files that
+// resolve to format under the format option, so that they render alike.
+func paneFiles(paths []string, option, format string) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			if len(paths) == 1 {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("%s is a directory; preview it on its own, not alongside other files", p)
+		}
+		if f, err := resolveFormat(option, p); err != nil || f != format {
+			return nil, fmt.Errorf("%s is not %s like %s; preview them separately", p, format, paths[0])
+		}
+		p = filepath.Clean(p)
+		if seen[p] {
+			return nil, fmt.Errorf("%s is given more than once", p)
+		}
+		seen[p] = true
+		files = append(files, p)
+	}
+	return files, nil
+}
+
+// panes reports whether several files are previewed side by side.
+func (s *Server) panes() bool {
+	return len(s.files) > 1
+}
+
+// documentName is the name of the document at p in ?file= queries and
+// render messages: its path relative to the previewed directory, or as
+// given when previewing several files.
+func (s *Server) documentName(p string) string {
+	switch {
+	case s.dir:
+		return s.relativePath(p)
+	case s.panes():
+		return filepath.ToSlash(p)
+	}
+	return filepath.Base(p)
+}
+
+// paneFile returns the previewed file named name, reporting false if there
+// is none.
+func (s *Server) paneFile(name string) (string, bool) {
+	for _, f := range s.files {
+		if s.documentName(f) == name {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// handlePanes serves the page showing each previewed file's own live
+// preview in a pane of its own.
+func (s *Server) handlePanes(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, len(s.files))
+	for i, f := range s.files {
+		names[i] = s.documentName(f)
+	}
+	data := s.indexData(s.path, false)
+	data["files"] = names
+	data["title"] = strings.Join(names, " · ")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.panesTemplate.Execute(w, data); err != nil {
+		s.log.WithError(err).Error("failed to render panes")
+	}
+}
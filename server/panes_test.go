@@ -0,0 +1,39 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPanesPage(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"a.md", "b.md"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("# "+name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	s := newTestServerFor(t, paths, Options{})
+	resp, err := http.Get(startTestServer(t, s).URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	page := string(body)
+	for _, name := range []string{"a.md", "b.md"} {
+		if !strings.Contains(page, `title="`+filepath.ToSlash(filepath.Join(dir, name))+`"></iframe>`) {
+			t.Errorf("panes page lacks a pane for %s:\n%s", name, page)
+		}
+	}
+	style, head := strings.Index(page, "<style>"), strings.Index(page, "</head>")
+	if style < 0 || style > head {
+		t.Errorf("panes page styles outside <head>:\n%s", page)
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// handlePrint serves the document as a standalone page laid out for
+// printing, so that the browser's print to PDF comes out clean. Relative
+// links and images still point at the assets route. Strict mode doesn't
+// apply: accessibility issues are marked on the page.
+func (s *Server) handlePrint(w http.ResponseWriter, r *http.Request) {
+	path, err := s.documentPath(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	d := &document{path: path}
+	rendered, err := s.render(d, renderRequest)
+	if err == nil {
+		rendered, err = postProcess(rendered, s.rewriteAssetURLs(path))
+	}
+	if err != nil {
+		s.log.WithError(err).Error("failed to render markdown")
+		http.Error(w, "Failed to render file", http.StatusInternalServerError)
+		return
+	}
+
+	var page bytes.Buffer
+	if err := s.writePage(&page, path, rendered, d.title, true); err != nil {
+		s.log.WithError(err).Error("failed to write print page")
+		http.Error(w, "Failed to render file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.Bytes())
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// getPrint fetches the print page of the server at url, failing the test
+// unless it is served.
+func getPrint(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url + "/print")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /print: status %d: %s", resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+func TestPrintStrict(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Title\n\n![](shot.png)\n", Options{Strict: true})
+	page := getPrint(t, startTestServer(t, s).URL)
+	// The issues are marked, but only exports fail on them.
+	if !strings.Contains(page, "a11y-warning") {
+		t.Errorf("print page doesn't mark the image without alt text:\n%s", page)
+	}
+}
+
+func TestPrintWithoutSideEffects(t *testing.T) {
+	fakeMermaidCLI(t)
+	var out lockedBuffer
+	s := newTestServer(t, "doc.md", diagramDoc, Options{RenderOutput: &out})
+	ts := startTestServer(t, s)
+	ws := dialTestServer(t, ts, "")
+	readConnected(t, ws)
+	printed := out.String()
+
+	page := getPrint(t, ts.URL)
+	if strings.Contains(page, "<text>drawn</text>") {
+		t.Errorf("print page was drawn with mmdc:\n%s", page)
+	}
+	if !strings.Contains(page, "graph TD") {
+		t.Errorf("print page lacks the diagram source:\n%s", page)
+	}
+	if got := out.String(); got != printed {
+		t.Errorf("print page copied to the render output:\n%s", strings.TrimPrefix(got, printed))
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// validReloadGroup matches reload group names, which become file names.
+var validReloadGroup = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// reloadGroupDir returns the directory through which the instances in a
+// reload group signal each other, creating it: mdpreview/reload under
+// $XDG_RUNTIME_DIR, or else the user's cache directory. Only the user may
+// use it, so other users can neither join nor signal a group, nor plant
+// links in it.
+func reloadGroupDir() (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		var err error
+		if base, err = os.UserCacheDir(); err != nil {
+			return "", err
+		}
+	}
+	dir := filepath.Join(base, "mdpreview", "reload")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	if info.Mode().Perm() != 0700 {
+		if err := os.Chmod(dir, 0700); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// newReloadID returns a name for an instance in its reload group signals,
+// telling its own apart from the others'.
+func newReloadID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return strconv.Itoa(os.Getpid()) + "-" + hex.EncodeToString(b)
+}
+
+// signalReloadGroup tells the other instances in the reload group that a
+// file changed. A signal is the sender's id and the time, written to a new
+// file that then replaces the group's, so that whatever is at the group's
+// path, such as a symlink, is never written through.
+func (s *Server) signalReloadGroup() {
+	if s.reloadGroup == "" {
+		return
+	}
+	if err := s.writeReloadSignal(); err != nil {
+		s.log.WithError(err).Warn("failed to signal reload group")
+	}
+}
+
+func (s *Server) writeReloadSignal() error {
+	dir, err := reloadGroupDir()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+s.reloadGroup+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = fmt.Fprintf(f, "%s %d\n", s.reloadID, time.Now().UnixNano())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, s.reloadGroup))
+}
+
+// readReloadSignal reads the signal at path, refusing anything but a
+// regular file.
+func readReloadSignal(path string) ([]byte, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	return os.ReadFile(path)
+}
+
+// watchReloadGroup re-renders every open document when another instance in
+// the reload group signals a change.
+func (s *Server) watchReloadGroup() {
+	dir, err := reloadGroupDir()
+	if err != nil {
+		s.log.WithError(err).Error("failed to join reload group")
+		return
+	}
+	path := filepath.Join(dir, s.reloadGroup)
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		s.log.WithError(err).Error("failed to create reload group watcher")
+		return
+	}
+	defer w.Close()
+	// Signals replace the group's file, so it is the directory that is
+	// watched.
+	if err := w.Add(dir); err != nil {
+		s.log.WithError(err).Error("failed to watch reload group")
+		return
+	}
+
+	self := []byte(s.reloadID + " ")
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if event.Name != path || !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			signal, err := readReloadSignal(path)
+			if err != nil || len(signal) == 0 || bytes.HasPrefix(signal, self) {
+				continue
+			}
+			s.log.WithField("group", s.reloadGroup).Debug("reload group signaled")
+			s.reloadAll()
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			s.log.WithError(err).Warn("reload group watcher error")
+		}
+	}
+}
+
+// reloadAll re-renders every open document.
+func (s *Server) reloadAll() {
+	s.docsMu.Lock()
+	defer s.docsMu.Unlock()
+	for _, d := range s.docs {
+		d.invalidate()
+		notify(d.changes)
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// privateRuntimeDir points reload groups at a fresh directory until the
+// test ends, returning where their files go.
+func privateRuntimeDir(t *testing.T) string {
+	t.Helper()
+	base := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", base)
+	return filepath.Join(base, "mdpreview", "reload")
+}
+
+func TestReloadGroup(t *testing.T) {
+	privateRuntimeDir(t)
+	a := newTestServer(t, "a.md", "# A\n", Options{ReloadGroup: "docs"})
+	b := newTestServer(t, "b.md", "# B\n", Options{ReloadGroup: "docs"})
+	other := newTestServer(t, "c.md", "# C\n", Options{ReloadGroup: "other"})
+	ws := dialTestServer(t, startTestServer(t, a), "")
+	readConnected(t, ws)
+	startTestServer(t, b)
+	otherWS := dialTestServer(t, startTestServer(t, other), "")
+	readConnected(t, otherWS)
+
+	// The watcher may not be up yet, so b signals until a renders.
+	got := make(chan wsMessage, 1)
+	go func() {
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var msg wsMessage
+		if err := ws.ReadJSON(&msg); err == nil {
+			got <- msg
+		}
+		close(got)
+	}()
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for done := false; !done; {
+		select {
+		case msg, ok := <-got:
+			if !ok || msg.Type != "render" {
+				t.Fatalf("a got %+v, want a render on b's signal", msg)
+			}
+			done = true
+		case <-tick.C:
+			b.signalReloadGroup()
+		}
+	}
+
+	// Instances in other groups, and the sender itself, aren't signaled.
+	otherWS.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if _, _, err := otherWS.ReadMessage(); err == nil {
+		t.Error("instance in another group re-rendered")
+	}
+}
+
+func TestReloadGroupIgnoresOwnSignal(t *testing.T) {
+	privateRuntimeDir(t)
+	s := newTestServer(t, "a.md", "# A\n", Options{ReloadGroup: "docs"})
+	ws := dialTestServer(t, startTestServer(t, s), "")
+	readConnected(t, ws)
+	for i := 0; i < 3; i++ {
+		s.signalReloadGroup()
+		time.Sleep(50 * time.Millisecond)
+	}
+	ws.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if _, _, err := ws.ReadMessage(); err == nil {
+		t.Error("instance re-rendered on its own signal")
+	}
+}
+
+func TestReloadGroupFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions and symlinks differ on Windows")
+	}
+	dir := privateRuntimeDir(t)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// A link planted at the group's path isn't written through.
+	victim := filepath.Join(t.TempDir(), "victim")
+	if err := os.WriteFile(victim, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(victim, filepath.Join(dir, "docs")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t, "a.md", "# A\n", Options{ReloadGroup: "docs"})
+	if err := s.writeReloadSignal(); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(victim); string(content) != "keep" {
+		t.Errorf("signal written through a symlink: %q", content)
+	}
+	info, err := os.Lstat(filepath.Join(dir, "docs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm() != 0600 {
+		t.Errorf("group file mode %v, want a regular file with 0600", info.Mode())
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("group directory mode %v, %v, want 0700", info.Mode(), err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("group directory holds %v, %v, want just the group's file", entries, err)
+	}
+}
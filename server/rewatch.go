@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// rewatchDelay is the first wait before re-adding the watch on a file
+	// that was removed or renamed, doubling up to rewatchMaxDelay while it
+	// stays missing.
+	rewatchDelay    = 100 * time.Millisecond
+	rewatchMaxDelay = time.Second
+	// missingAfter is how long a file may be missing, as between an
+	// editor's delete and rename, before viewers are told it is gone.
+	missingAfter = 2 * time.Second
+)
+
+// rewatch restores the watch on d's file after it was removed or renamed,
+// sending d to restored once the file is back, whether saved into place by an
+// editor or recreated later. Viewers are told if it stays missing for
+// missingAfter; the preview resumes when it returns.
+func (s *Server) rewatch(w *fsnotify.Watcher, d *document, restored chan<- *document) {
+	start := time.Now()
+	reported := false
+	for delay := rewatchDelay; ; delay = min(2*delay, rewatchMaxDelay) {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		err := w.Add(d.path)
+		if err == nil {
+			if reported {
+				s.log.WithField("file", d.path).Info("file is back")
+			}
+			select {
+			case restored <- d:
+			case <-s.ctx.Done():
+			}
+			return
+		}
+		s.log.WithError(err).Debug("failed to re-add watch")
+		if !reported && time.Since(start) >= missingAfter {
+			reported = true
+			s.log.WithField("file", d.path).Warn("file no longer exists, waiting for it to come back")
+			msg := wsMessage{Type: "error", File: s.documentName(d.path), Error: fmt.Sprintf("%s no longer exists. The preview resumes if it comes back.", filepath.Base(d.path))}
+			if m, err := s.encodeMessage(msg); err == nil {
+				m.transient = true
+				d.hub.publish(d.ctx, m)
+			}
+		}
+	}
+}
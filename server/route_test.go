@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRouteBase(t *testing.T) {
+	for route, want := range map[string]string{
+		"/":              "./",
+		"/preview":       "./",
+		"/docs/preview":  "../",
+		"/docs/preview/": "../../",
+	} {
+		if got := routeBase(route); got != want {
+			t.Errorf("routeBase(%q) = %q, want %q", route, got, want)
+		}
+	}
+}
+
+// pageURLs matches the static files and WebSocket base a page refers to.
+var pageURLs = regexp.MustCompile(`(?:href|src|data-base)="([^"#]+)"`)
+
+func TestCustomRoute(t *testing.T) {
+	for _, route := range []string{"/preview", "/docs/preview", "/docs/preview/"} {
+		s := newTestServer(t, "doc.md", "# Doc\n", Options{Route: route})
+		ts := startTestServer(t, s)
+		page, err := url.Parse(ts.URL + route)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Get(page.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "preview.js") {
+			t.Fatalf("GET %s: status %d, not the preview page", route, resp.StatusCode)
+		}
+
+		// Every relative URL on the page resolves to something served.
+		matches := pageURLs.FindAllStringSubmatch(string(body), -1)
+		if len(matches) == 0 {
+			t.Fatalf("no URLs found on the page at %s", route)
+		}
+		for _, m := range matches {
+			ref, err := url.Parse(m[1])
+			if err != nil || ref.IsAbs() || strings.HasPrefix(ref.Path, "/") {
+				continue
+			}
+			resolved := page.ResolveReference(ref)
+			if strings.HasPrefix(m[0], "data-base") {
+				if resolved.Path != "/" {
+					t.Errorf("route %s: WebSocket base %q resolves to %s, want /", route, m[1], resolved.Path)
+				}
+				continue
+			}
+			resp, err := http.Get(resolved.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("route %s: %s resolves to %s, status %d", route, m[1], resolved.Path, resp.StatusCode)
+			}
+		}
+
+		// The root sends readers on to the route.
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err = client.Get(ts.URL + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != route {
+			t.Errorf("GET / with route %s: status %d to %q", route, resp.StatusCode, resp.Header.Get("Location"))
+		}
+	}
+}
+
+func TestInvalidRoute(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.md")
+	if err := os.WriteFile(path, []byte("# Doc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range []string{"preview", "/a/../b"} {
+		_, err := New(context.Background(), []string{path}, testLogger(), Options{Route: route})
+		if err == nil || !strings.Contains(err.Error(), "invalid route") {
+			t.Errorf("route %q: error %v, want it refused", route, err)
+		}
+	}
+}
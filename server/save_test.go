@@ -0,0 +1,71 @@
+package server
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// contentsUntil reads messages from ws until the content want, returning
+// the contents sent before it.
+func contentsUntil(t *testing.T, ws *websocket.Conn, want string) []string {
+	t.Helper()
+	var contents []string
+	for {
+		msg := readType(t, ws, "content")
+		if msg.Content == want {
+			return contents
+		}
+		contents = append(contents, msg.Content)
+	}
+}
+
+func TestSaveEcho(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{AllowSave: true})
+	ts := startTestServer(t, s)
+	saver := dialTestServer(t, ts, "")
+	readConnected(t, saver)
+	viewer := dialTestServer(t, ts, "")
+	readConnected(t, viewer)
+
+	if err := saver.WriteJSON(wsMessage{Type: "save", Content: "# Saved\n"}); err != nil {
+		t.Fatal(err)
+	}
+	// Both are sent the render, but only the other client the content.
+	waitForRender(t, saver, "Saved")
+	waitForRender(t, viewer, "Saved")
+	contentsUntil(t, viewer, "# Saved\n")
+
+	// Edits from elsewhere reach the saving client too.
+	if err := os.WriteFile(s.path, []byte("# External\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range contentsUntil(t, saver, "# External\n") {
+		if content == "# Saved\n" {
+			t.Error("saving client sent back the content it saved")
+		}
+	}
+}
+
+func TestNoContentWithoutSave(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{})
+	ws := dialTestServer(t, startTestServer(t, s), "")
+	readConnected(t, ws)
+	if err := os.WriteFile(s.path, []byte("# Changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForRender(t, ws, "Changed")
+	// Only renders come, until the read times out.
+	ws.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	for {
+		var msg wsMessage
+		if err := ws.ReadJSON(&msg); err != nil {
+			break
+		}
+		if msg.Type == "content" {
+			t.Errorf("content sent after a change without -allow-save: %q", msg.Content)
+		}
+	}
+}
@@ -2,87 +2,621 @@ package server
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"embed"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
-	"github.com/shurcooL/github_flavored_markdown"
 	"github.com/sirupsen/logrus"
 )
 
 //go:embed static/*
 var staticFiles embed.FS
 
+// settlingHTML is shown in place of the preview until the initial render
+// delay has elapsed.
+const settlingHTML = `<p class="settling">Waiting for file to settle…</p>`
+
+// frameGzip is the header byte of a binary WebSocket message whose payload is
+// gzip-compressed UTF-8 text.
+const frameGzip byte = 1
+
+// wsMessage is the JSON schema of every WebSocket message. The server sends
+//
+//	{"type":"content","content":...}  the file's source, on connect and,
+//	                                  with saving allowed, after each
+//	                                  render
+//	{"type":"render","html":...}      a render to show in the preview, with
+//	                                  the document's "title", its
+//	                                  "reading" length and "stats" about
+//	                                  it in diagnostics mode
+//	{"type":"error","error":...}      a problem to show the viewer
+//
+// each with the "file" it is about, the document's name in ?file= queries;
+// and clients send {"type":"save","content":...} to write the file, if
+// saving is allowed, {"type":"toggle","line":...,"text":...,"checked":...}
+// to check or uncheck the task list item on a line, likewise, if the line
+// still reads text, and {"type":"resync"} to be sent a fresh render. A
+// client is sent the render of content it saved, but not the content
+// itself.
+type wsMessage struct {
+	Type    string        `json:"type"`
+	File    string        `json:"file,omitempty"`
+	Content string        `json:"content,omitempty"`
+	HTML    string        `json:"html,omitempty"`
+	Title   string        `json:"title,omitempty"`
+	Stats   *renderStats  `json:"stats,omitempty"`
+	Reading *readingStats `json:"reading,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	// Line and Checked say which task list item a toggle message checks or
+	// unchecks, and Text what the line read when the preview showed it.
+	Line    int    `json:"line,omitempty"`
+	Text    string `json:"text,omitempty"`
+	Checked bool   `json:"checked,omitempty"`
+}
+
+// Options configures optional Server behavior.
+type Options struct {
+	// RenderLocally renders Markdown in-process instead of via the GitHub API.
+	RenderLocally bool
+	// Engine is the Markdown engine for local renders, and for API renders
+	// that fail: EngineGFM (the default) or EngineGoldmark.
+	Engine string
+	// Renderer, if set, renders Markdown in place of Engine or the API.
+	Renderer Renderer
+	// InitialRenderDelay postpones the first render after startup so that a
+	// file still being generated by another tool can settle.
+	InitialRenderDelay time.Duration
+	// AllowedOrigins lists the cross-origin pages, such as hosts of the
+	// embeddable widget, that may fetch content and open the WebSocket. "*"
+	// allows any origin.
+	AllowedOrigins []string
+	// ReadRetries is how many times a failed read of the file is retried
+	// before giving up. A missing file is never retried.
+	ReadRetries int
+	// ReadBackoff is the wait before the first retry. It doubles on each
+	// subsequent retry.
+	ReadBackoff time.Duration
+	// Theme is ThemeLight, ThemeDark or ThemeAuto (the default). In the live
+	// preview auto follows the browser; static output asks the OS instead.
+	Theme string
+	// RenderOutput, when set, receives a copy of the HTML from every render
+	// for debugging.
+	RenderOutput io.Writer
+	// RenderOutputLimit truncates what is written to RenderOutput to this
+	// many bytes. Zero writes renders in full.
+	RenderOutputLimit int
+	// BinaryMessages sends renders as gzip-compressed binary WebSocket
+	// messages instead of text messages.
+	BinaryMessages bool
+	// Format is FormatMarkdown, FormatHTML, or FormatAuto (the default) to
+	// pick one by file extension. HTML files are previewed as is.
+	Format string
+	// SanitizeHTML strips unsafe markup such as scripts from HTML files.
+	SanitizeHTML bool
+	// Autolink controls linking of bare URLs: AutolinkOn (the default),
+	// AutolinkOff or AutolinkWWW. Explicit links are never affected.
+	Autolink string
+	// GitHubToken authenticates API renders, raising GitHub's rate limit.
+	GitHubToken string
+	// Debounce collapses a burst of file events, such as an editor saving
+	// in several writes, into one render once the file has been quiet this
+	// long. Zero renders on every event.
+	Debounce time.Duration
+	// PingInterval is how often clients are pinged to keep their connection
+	// alive, 2s if zero.
+	PingInterval time.Duration
+	// WSTimeout is how long a client may go without answering a ping before
+	// it is dropped, 60s if zero. It must be at least twice PingInterval.
+	WSTimeout time.Duration
+	// WriteTimeout is how long a single message to a client may take to
+	// send before the client is dropped, 10s if zero.
+	WriteTimeout time.Duration
+	// CodeTheme is the Chroma style, such as "github" (the default) or
+	// "monokai", used to highlight code blocks in local renders. The GitHub
+	// API highlights code itself.
+	CodeTheme string
+	// ValidateCode marks JSON, YAML and TOML code blocks that don't parse.
+	ValidateCode bool
+	// A11y marks images without alt text and headings that skip a level.
+	A11y bool
+	// Strict makes Render fail when the document has accessibility issues,
+	// for use in CI. It implies A11y.
+	Strict bool
+	// Diagnostics adds timing stats to every render sent to the preview,
+	// which graphs them.
+	Diagnostics bool
+	// LogStats logs how long each render took to read and render the file,
+	// and each update to write to the browser.
+	LogStats bool
+	// HighlightChanges makes the preview briefly highlight the blocks that
+	// changed in each render.
+	HighlightChanges bool
+	// ContentOnly makes the watcher ignore events that leave the file's
+	// content as last rendered, such as touch or chmod, without scheduling
+	// a render at all.
+	ContentOnly bool
+	// Comments enables review comments: Alt+click a block in the preview to
+	// comment on it. Comments are kept in a sidecar file next to the
+	// document, named like README.md.comments.json.
+	Comments bool
+	// ClientDebug makes the preview page log connection and message events
+	// to the browser console. Otherwise it only logs errors.
+	ClientDebug bool
+	// NoFrontMatter leaves YAML front matter at the start of Markdown files
+	// to the Markdown renderer instead of showing it as a table.
+	NoFrontMatter bool
+	// NoMath leaves $...$ and $$...$$ in Markdown to the Markdown renderer
+	// instead of rendering it as math with KaTeX.
+	NoMath bool
+	// Smart curls quotes and turns -- and --- into en and em dashes and ...
+	// into an ellipsis in Markdown, outside of code.
+	Smart bool
+	// Route is the path serving the preview page, "/" by default. Static
+	// files and the WebSocket stay at the server root, and "/" redirects to
+	// the route.
+	Route string
+	// ShareKey signs share links, see ShareQuery. When set, requests from
+	// other hosts need a valid link and may only view the preview.
+	ShareKey []byte
+	// BasicAuth, as "user:pass", makes every request authenticate with HTTP
+	// basic auth, other than read-only ones with a share link.
+	BasicAuth string
+	// ReloadGroup, when set, names a group of instances on this machine that
+	// re-render whenever a file previewed by any of them changes, such as
+	// pages sharing an include.
+	ReloadGroup string
+	// AllowSave lets clients write the file with save messages. Without it
+	// the preview is read-only.
+	AllowSave bool
+	// StaticDir, for working on mdpreview itself, serves the static files
+	// from this directory on disk, uncached, instead of the bundled ones.
+	// Page templates are still bundled.
+	StaticDir string
+	// WatchExtra lists files the preview depends on besides the document,
+	// such as includes or a stylesheet. Changes to them re-render it.
+	WatchExtra []string
+	// Cover shows the title, subtitle, author and date from front matter as
+	// a title block in place of the front matter table.
+	Cover bool
+	// Template is an HTML template file to use for the preview page instead
+	// of the built-in one, which is used if the file is missing. It gets the
+	// same data as the built-in static/index.html.
+	Template string
+	// CSS is a stylesheet file applied after the built-in styles, served at
+	// /custom.css and inlined into standalone pages.
+	CSS string
+	// EmojiStyle is how :shortcode: emoji are shown in local renders:
+	// EmojiUnicode (the default) or EmojiTwemoji.
+	EmojiStyle string
+	// NoEmoji leaves :shortcode: emoji as written, for documents about the
+	// syntax itself.
+	NoEmoji bool
+	// Version and Commit identify the build, reported at /version.
+	Version string
+	Commit  string
+}
+
 // Server serves a HTML rendered Markdown preview of a Markdown file specified
 // at path. Whenever the path is written to, the rendering will update
-// dynamically.
+// dynamically. If path is a directory, every Markdown file under it can be
+// previewed, picked from a listing. Several files are previewed side by
+// side.
 type Server struct {
-	ctx           context.Context
-	path          string
-	indexTemplate *template.Template
-	upgrader      websocket.Upgrader
-	log           *logrus.Logger
-	renderLocally bool
+	ctx  context.Context
+	path string
+	dir  bool
+	// files lists the previewed files, unless a directory is previewed.
+	// The first is path.
+	files           []string
+	indexTemplate   *template.Template
+	listingTemplate *template.Template
+	panesTemplate   *template.Template
+	upgrader        websocket.Upgrader
+	log             *logrus.Logger
+	renderLocally   bool
+	renderer        Renderer
+	settleUntil     time.Time
+	origins         []string
+	readRetries     int
+	readBackoff     time.Duration
+	debounce        time.Duration
+	pingInterval    time.Duration
+	wsTimeout       time.Duration
+	writeWait       time.Duration
+	theme           string
+	route           string
+	shareKey        []byte
+	basicAuth       string
+	reloadGroup     string
+	reloadID        string
+	allowSave       bool
+	staticDir       string
+	watchExtra      map[string]bool
+
+	printMu    sync.Mutex
+	printOut   io.Writer
+	printLimit int
+
+	binaryMessages bool
+	format         string
+	sanitizeHTML   bool
+	autolink       string
+	githubToken    string
+	clientDebug    bool
+	validateCode   bool
+	noFrontMatter  bool
+	cover          bool
+	customCSS      string
+	emojiStyle     string
+	noEmoji        bool
+	noMath         bool
+	smart          bool
+	a11y           bool
+	strict         bool
+	codeTheme      string
+	version        string
+	commit         string
+
+	comments   bool
+	commentsMu sync.Mutex
+
+	// assetRefs holds, by document, the files its last render pointed at
+	// through the assets route: all that a share link to it may fetch.
+	assetRefsMu sync.Mutex
+	assetRefs   map[string]map[string]bool
+
+	// mermaidCLI is where mmdc is installed, once mermaidOnce has looked.
+	mermaidOnce sync.Once
+	mermaidCLI  string
+
+	contentOnly      bool
+	diagnostics      bool
+	highlightChanges bool
+	logStats         bool
+	// engine names what renders Markdown, for stats.
+	engine  string
+	metrics metrics
+
+	// docs holds the documents being viewed, by path.
+	docsMu sync.Mutex
+	docs   map[string]*document
+	// exported is the document Render renders, titling WritePage's page.
+	exported *document
+	// wg tracks the goroutines started by Run and open connections.
+	wg sync.WaitGroup
 }
 
-// New creates a new Server given some markdown path.
-func New(ctx context.Context, path string, log *logrus.Logger, renderLocally bool) (*Server, error) {
+// New creates a new Server given some markdown paths: a file, a directory, or
+// several files to preview side by side.
+func New(ctx context.Context, paths []string, log *logrus.Logger, opts Options) (*Server, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no file or directory to preview")
+	}
+	path := paths[0]
 	indexData, err := staticFiles.ReadFile("static/index.html")
 	if err != nil {
 		return nil, err
 	}
+	if opts.Template != "" {
+		custom, err := os.ReadFile(opts.Template)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			log.WithField("template", opts.Template).Warn("template not found, using the built-in one")
+		case err != nil:
+			return nil, err
+		default:
+			indexData = custom
+		}
+	}
 
 	indexTemplate, err := template.New("index").Parse(string(indexData))
 	if err != nil {
 		return nil, err
 	}
+	listingData, err := staticFiles.ReadFile("static/listing.html")
+	if err != nil {
+		return nil, err
+	}
+	listingTemplate, err := template.New("listing").Parse(string(listingData))
+	if err != nil {
+		return nil, err
+	}
+	panesData, err := staticFiles.ReadFile("static/panes.html")
+	if err != nil {
+		return nil, err
+	}
+	panesTemplate, err := template.New("panes").Parse(string(panesData))
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Theme == "" {
+		opts.Theme = ThemeAuto
+	}
+	if err := validTheme(opts.Theme); err != nil {
+		return nil, err
+	}
+	format, err := resolveFormat(opts.Format, path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() && format != FormatMarkdown {
+		return nil, fmt.Errorf("format %q is not supported for a directory", format)
+	}
+	files, err := paneFiles(paths, opts.Format, format)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Autolink == "" {
+		opts.Autolink = AutolinkOn
+	}
+	if err := validAutolink(opts.Autolink); err != nil {
+		return nil, err
+	}
+	if opts.Engine == "" {
+		opts.Engine = EngineGFM
+	}
+	if err := validEngine(opts.Engine); err != nil {
+		return nil, err
+	}
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = DefaultPingInterval
+	}
+	if opts.WSTimeout <= 0 {
+		opts.WSTimeout = DefaultWSTimeout
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = DefaultWriteTimeout
+	}
+	if opts.WSTimeout < 2*opts.PingInterval {
+		return nil, fmt.Errorf("WebSocket timeout %s is too short for the ping interval %s: make it at least twice as long", opts.WSTimeout, opts.PingInterval)
+	}
+	if opts.EmojiStyle == "" {
+		opts.EmojiStyle = EmojiUnicode
+	}
+	if err := validEmojiStyle(opts.EmojiStyle); err != nil {
+		return nil, err
+	}
+	if opts.CodeTheme == "" {
+		opts.CodeTheme = DefaultCodeTheme
+	}
+	if err := validCodeTheme(opts.CodeTheme); err != nil {
+		return nil, err
+	}
+	if opts.Route == "" {
+		opts.Route = "/"
+	}
+	if !strings.HasPrefix(opts.Route, "/") || strings.Contains(opts.Route, "..") {
+		return nil, fmt.Errorf("invalid route %q: must be an absolute path", opts.Route)
+	}
+	if opts.BasicAuth != "" && !strings.Contains(opts.BasicAuth, ":") {
+		return nil, errors.New("basic auth must be given as user:pass")
+	}
+	if opts.ReloadGroup != "" && !validReloadGroup.MatchString(opts.ReloadGroup) {
+		return nil, fmt.Errorf("invalid reload group %q: use letters, digits, - and _", opts.ReloadGroup)
+	}
+
+	s := &Server{
+		ctx:             ctx,
+		path:            path,
+		dir:             info.IsDir(),
+		exported:        &document{path: path},
+		files:           files,
+		log:             log,
+		indexTemplate:   indexTemplate,
+		listingTemplate: listingTemplate,
+		panesTemplate:   panesTemplate,
+		renderLocally:   opts.RenderLocally,
+		renderer:        opts.Renderer,
+		settleUntil:     time.Now().Add(opts.InitialRenderDelay),
+		origins:         opts.AllowedOrigins,
+		readRetries:     opts.ReadRetries,
+		readBackoff:     opts.ReadBackoff,
+		debounce:        opts.Debounce,
+		pingInterval:    opts.PingInterval,
+		wsTimeout:       opts.WSTimeout,
+		writeWait:       opts.WriteTimeout,
+		theme:           opts.Theme,
+		route:           opts.Route,
+		shareKey:        opts.ShareKey,
+		basicAuth:       opts.BasicAuth,
+		reloadGroup:     opts.ReloadGroup,
+		reloadID:        newReloadID(),
+		allowSave:       opts.AllowSave,
+		staticDir:       opts.StaticDir,
+		watchExtra:      map[string]bool{},
+		printOut:        opts.RenderOutput,
+		printLimit:      opts.RenderOutputLimit,
+
+		binaryMessages: opts.BinaryMessages,
+		format:         format,
+		sanitizeHTML:   opts.SanitizeHTML,
+		autolink:       opts.Autolink,
+		githubToken:    opts.GitHubToken,
+		clientDebug:    opts.ClientDebug,
+		validateCode:   opts.ValidateCode,
+		noFrontMatter:  opts.NoFrontMatter,
+		cover:          opts.Cover,
+		customCSS:      opts.CSS,
+		emojiStyle:     opts.EmojiStyle,
+		noEmoji:        opts.NoEmoji,
+		noMath:         opts.NoMath,
+		smart:          opts.Smart,
+		a11y:           opts.A11y || opts.Strict,
+		strict:         opts.Strict,
+		codeTheme:      opts.CodeTheme,
+		version:        opts.Version,
+		commit:         opts.Commit,
+		comments:       opts.Comments,
+		contentOnly:    opts.ContentOnly,
+		diagnostics:    opts.Diagnostics,
+
+		highlightChanges: opts.HighlightChanges,
+		logStats:         opts.LogStats,
+		engine:           opts.Engine,
+		docs:             make(map[string]*document),
+		assetRefs:        make(map[string]map[string]bool),
+	}
+	s.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     s.checkOrigin,
+	}
+	switch {
+	case s.format == FormatHTML:
+		s.engine = FormatHTML
+	case s.renderer != nil:
+		s.engine = "custom"
+	case !s.renderLocally:
+		s.engine = "github"
+	}
+	if s.renderer == nil {
+		s.renderer = newEngine(opts.Engine)
+		if !s.renderLocally {
+			s.renderer = githubRenderer{s, s.renderer}
+		}
+	}
+	for _, p := range opts.WatchExtra {
+		s.watchExtra[filepath.Clean(p)] = true
+	}
+	return s, nil
+}
 
-	return &Server{
-		ctx:           ctx,
-		path:          path,
-		log:           log,
-		indexTemplate: indexTemplate,
-		upgrader: websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				// Only allow same-origin connections for security
-				origin := r.Header.Get("Origin")
-				return origin == "" || origin == "http://"+r.Host
-			},
-		},
-		renderLocally: renderLocally,
-	}, nil
-}
-
-// Run returns handlers to run the server.
+// checkOrigin allows same-origin requests, over HTTP or HTTPS, and those from
+// explicitly allowed origins.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || origin == "http://"+r.Host || origin == "https://"+r.Host || s.crossOriginAllowed(origin)
+}
+
+func (s *Server) crossOriginAllowed(origin string) bool {
+	for _, o := range s.origins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// cors adds CORS headers for allowed cross-origin requests so that the
+// embeddable widget can load styles and content from another page.
+func (s *Server) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && s.crossOriginAllowed(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Run starts watching and rendering the files and returns handlers to serve
+// the preview. Rendering stops when the Server's context is canceled. In
+// directory mode files are rendered while someone is viewing them.
 func (s *Server) Run() (http.Handler, error) {
+	if s.dir {
+		s.goTracked(s.watchDir)
+	} else {
+		// The files stay open for as long as the server runs.
+		docs := make([]*document, len(s.files))
+		for i, f := range s.files {
+			docs[i] = s.openDocument(f)
+		}
+		s.goTracked(func() { s.watchFiles(docs) })
+	}
+	if s.reloadGroup != "" {
+		s.goTracked(s.watchReloadGroup)
+	}
 	return s.setupHandlers(), nil
 }
 
-func (s *Server) setupHandlers() http.Handler {
-	staticFileHandler := http.FileServer(http.FS(staticFiles))
+// Wait blocks until everything started by Run and every WebSocket
+// connection has shut down after the Server's context is canceled, or
+// until ctx is done.
+func (s *Server) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
+func (s *Server) goTracked(f func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		f()
+	}()
+}
+
+func (s *Server) setupHandlers() http.Handler {
 	r := mux.NewRouter()
-	r.HandleFunc("/", s.handleIndex).Methods("GET")
+	r.HandleFunc(s.route, s.handleIndex).Methods("GET")
+	if s.route != "/" {
+		r.Handle("/", http.RedirectHandler(s.route, http.StatusFound)).Methods("GET")
+	}
 	r.HandleFunc("/ws", s.handleWebSocket).Methods("GET")
 	r.HandleFunc("/content", s.handleGetContent).Methods("GET")
-	r.PathPrefix("/").Handler(staticFileHandler).Methods("GET")
+	r.HandleFunc("/print", s.handlePrint).Methods("GET")
+	r.HandleFunc("/version", s.handleVersion).Methods("GET")
+	r.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
+	r.HandleFunc("/render", s.handleRender).Methods("POST")
+	if s.customCSS != "" {
+		r.HandleFunc("/custom.css", s.handleCustomCSS).Methods("GET")
+	}
+	r.PathPrefix(assetsPrefix).HandlerFunc(s.handleAsset).Methods("GET")
+	if s.comments {
+		r.HandleFunc("/comments", s.handleGetComments).Methods("GET")
+		r.HandleFunc("/comments", s.handleAddComment).Methods("POST")
+		r.HandleFunc("/comments/{id}", s.handleDeleteComment).Methods("DELETE")
+	}
+	r.PathPrefix("/").Handler(s.staticHandler()).Methods("GET")
 
-	return r
+	// The health check is outside the guard, for monitors without
+	// credentials.
+	root := mux.NewRouter()
+	root.HandleFunc("/healthz", handleHealth).Methods("GET")
+	root.PathPrefix("/").Handler(s.cors(s.guard(r)))
+	return root
 }
 
 func (s *Server) handleGetContent(w http.ResponseWriter, r *http.Request) {
-	content, err := os.ReadFile(s.path)
+	path, err := s.documentPath(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	content, err := s.readFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "File no longer exists", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		s.log.WithError(err).Error("failed to read file")
 		http.Error(w, "Failed to read file", http.StatusInternalServerError)
@@ -94,11 +628,25 @@ func (s *Server) handleGetContent(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if s.dir && r.URL.Query().Get("file") == "" {
+		s.handleListing(w, r)
+		return
+	}
+	if s.panes() && r.URL.Query().Get("file") == "" {
+		s.handlePanes(w, r)
+		return
+	}
+	path, err := s.documentPath(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := s.indexData(path, false)
+	// Task list checkboxes can only be toggled by readers who may save.
+	data["save"] = s.allowSave && !readOnly(r)
 	indexBuf := new(bytes.Buffer)
-	err := s.indexTemplate.Execute(indexBuf, map[string]interface{}{
-		"path": filepath.Base(s.path),
-	})
+	err = s.indexTemplate.Execute(indexBuf, data)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -106,7 +654,55 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	w.Write(indexBuf.Bytes())
 }
 
+// indexData is the data handed to the index template for the page showing
+// path. Static pages have no browser script to follow the reader's color
+// scheme, so an auto theme is resolved against the OS appearance for them.
+// The word count and reading time are of the file as it is now; the live
+// preview updates them with every render.
+func (s *Server) indexData(path string, static bool) map[string]interface{} {
+	theme := s.theme
+	if static {
+		theme = resolveTheme(theme, osAppearance)
+	}
+	data := map[string]interface{}{
+		"path":      filepath.Base(path),
+		"title":     filepath.Base(path),
+		"katex":     !s.noMath && katexEmbedded(),
+		"mermaid":   mermaidEmbedded(),
+		"dir":       s.dir,
+		"route":     s.route,
+		"base":      routeBase(s.route),
+		"theme":     theme,
+		"debug":     s.clientDebug,
+		"static":    static,
+		"comments":  s.comments,
+		"changes":   s.highlightChanges,
+		"customCSS": s.customCSS != "",
+	}
+	if input, err := s.readFile(path); err == nil {
+		body, _, _ := s.frontMatter(input)
+		reading := countWords(body)
+		data["words"] = reading.Words
+		data["minutes"] = reading.Minutes
+	}
+	return data
+}
+
+// routeBase is the relative URL from a page served at route back to the
+// server root, where static files and the WebSocket are.
+func routeBase(route string) string {
+	if depth := strings.Count(route, "/") - 1; depth > 0 {
+		return strings.Repeat("../", depth)
+	}
+	return "./"
+}
+
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	path, err := s.documentPath(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
 	ws, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		if _, ok := err.(websocket.HandshakeError); !ok {
@@ -115,38 +711,340 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go s.writer(ws)
-	s.reader(ws)
+	s.wg.Add(1)
+	defer s.wg.Done()
+
+	d := s.openDocument(path)
+	defer s.closeDocument(d)
+
+	c := newClient(ws, s.writeWait)
+	c.readOnly = readOnly(r)
+	if !d.hub.subscribe(d.ctx, c) {
+		ws.Close()
+		return
+	}
+	defer d.hub.unsubscribe(d.ctx, c)
+
+	s.goTracked(func() { s.writer(c) })
+	s.reader(c, d)
 }
 
-func (s *Server) render() ([]byte, error) {
-	input, err := os.ReadFile(s.path)
-	if err != nil {
-		return nil, err
+// readFile reads a previewed file, retrying transient failures such as the
+// file being replaced mid-save. A file that does not exist is reported
+// straight away.
+func (s *Server) readFile(path string) ([]byte, error) {
+	backoff := s.readBackoff
+	for attempt := 1; ; attempt++ {
+		content, err := os.ReadFile(path)
+		if err == nil || errors.Is(err, fs.ErrNotExist) || attempt > s.readRetries {
+			return content, err
+		}
+		s.log.WithError(err).WithField("attempt", attempt).Debug("retrying file read")
+		select {
+		case <-s.ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Render renders the file once to an HTML fragment, as shown inside the
+// preview page. Relative links are left as written so that the output works
+// saved next to the file.
+func (s *Server) Render() ([]byte, error) {
+	if s.dir {
+		return nil, fmt.Errorf("%s is a directory; render a file instead", s.path)
 	}
+	return s.render(s.exported, renderExport)
+}
+
+// WritePage writes rendered, as returned by Render, to w wrapped in the
+// preview page. The page is standalone: styles are inlined and it does not
+// connect back to a server. Only math and Mermaid diagrams that couldn't be
+// drawn beforehand need scripts, KaTeX and the Mermaid runtime, which are
+// inlined when they were embedded. Without them, math and diagrams show as
+// their source.
+func (s *Server) WritePage(w io.Writer, rendered []byte) error {
+	return s.writePage(w, s.path, rendered, s.exportedTitle(), false)
+}
 
-	if s.renderLocally {
-		return github_flavored_markdown.Markdown(input), nil
+// WritePrintPage is WritePage laid out for printing, e.g. to PDF: black on
+// white whatever the theme, without anchors, comments or warnings.
+func (s *Server) WritePrintPage(w io.Writer, rendered []byte) error {
+	return s.writePage(w, s.path, rendered, s.exportedTitle(), true)
+}
+
+// exportedTitle is the title of the document as Render last rendered it.
+func (s *Server) exportedTitle() string {
+	s.exported.hashMu.Lock()
+	defer s.exported.hashMu.Unlock()
+	return s.exported.title
+}
+
+// writePage writes the page for the document at path, titled title, or
+// after the file without one.
+func (s *Server) writePage(w io.Writer, path string, rendered []byte, title string, print bool) error {
+	css, err := staticFiles.ReadFile("static/github.css")
+	if err != nil {
+		return err
+	}
+	data := s.indexData(path, true)
+	if print {
+		data["theme"] = ThemeLight
+		data["print"] = true
 	}
+	if title != "" {
+		data["title"] = title
+	}
+	data["css"] = template.CSS(css)
+	if s.customCSS != "" {
+		custom, err := os.ReadFile(s.customCSS)
+		if err != nil {
+			return err
+		}
+		data["customStyle"] = template.CSS(custom)
+	}
+	data["content"] = template.HTML(rendered)
+	if bytes.Contains(rendered, []byte(`class="mermaid"`)) && mermaidEmbedded() {
+		mermaidJS, err := staticFiles.ReadFile(mermaidFile)
+		if err != nil {
+			return err
+		}
+		data["mermaidJS"] = inlineScript(mermaidJS)
+	}
+	if bytes.Contains(rendered, []byte(`class="math `)) && katexEmbedded() {
+		katex, err := fs.Sub(staticFiles, katexDir)
+		if err != nil {
+			return err
+		}
+		katexJS, katexCSS, err := inlineKaTeX(katex)
+		if err != nil {
+			return err
+		}
+		mathJS, err := staticFiles.ReadFile("static/math.js")
+		if err != nil {
+			return err
+		}
+		data["katexJS"] = inlineScript(katexJS)
+		data["katexCSS"] = template.CSS(katexCSS)
+		data["mathJS"] = inlineScript(mathJS)
+	}
+	return s.indexTemplate.Execute(w, data)
+}
 
-	// Use GitHub API for rendering
-	req, err := http.NewRequestWithContext(s.ctx, "POST", "https://api.github.com/markdown/raw", bytes.NewReader(input))
+// renderMode is what a render is for.
+type renderMode int
+
+const (
+	// renderLive renders for the preview: relative URLs point at the assets
+	// route and the render is cached until the content changes.
+	renderLive renderMode = iota
+	// renderExport renders a standalone page, drawing diagrams with mmdc
+	// and failing in strict mode if there are accessibility issues.
+	renderExport
+	// renderRequest renders for an HTTP request or a check, without running
+	// mmdc, enforcing strict mode or printing the render.
+	renderRequest
+)
+
+// render renders d in the given mode.
+func (s *Server) render(d *document, mode renderMode) ([]byte, error) {
+	start := time.Now()
+	input, err := s.readFile(d.path)
+	readTime := time.Since(start)
 	if err != nil {
+		s.metrics.renderFailed()
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "text/plain")
+	sum := sha256.Sum256(input)
+	if mode == renderLive {
+		d.hashMu.Lock()
+		cached := d.cached
+		if sum != d.renderedHash {
+			cached = nil
+		}
+		d.hashMu.Unlock()
+		if cached != nil {
+			s.log.Debug("content unchanged, reusing last render")
+			s.metrics.cacheHit()
+			return cached, nil
+		}
+	}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	start = time.Now()
+	rendered, title, err := s.renderInput(d.path, input, mode)
+	renderTime := time.Since(start)
 	if err != nil {
+		s.metrics.renderFailed()
 		return nil, err
 	}
-	defer resp.Body.Close()
+	s.metrics.rendered(readTime, renderTime, len(rendered))
+	if s.logStats {
+		s.log.WithFields(logrus.Fields{
+			"file":       d.path,
+			"bytes":      len(input),
+			"html_bytes": len(rendered),
+			"read_ms":    milliseconds(readTime),
+			"render_ms":  milliseconds(renderTime),
+			"engine":     s.engine,
+		}).Info("rendered")
+	}
+	if title == "" {
+		title = filepath.Base(d.path)
+	}
+	body, _, _ := s.frontMatter(input)
+	d.hashMu.Lock()
+	d.renderedHash = sum
+	d.reading = countWords(body)
+	d.title = title
+	if mode == renderLive {
+		d.cached = rendered
+	}
+	d.hashMu.Unlock()
+	if mode != renderRequest {
+		s.printRendered(rendered)
+	}
+	return rendered, nil
+}
+
+// renderInput renders input, the content of the file at path, or of no file
+// if path is empty. The title is that of the front matter, else of the
+// first h1, else "".
+func (s *Server) renderInput(path string, input []byte, mode renderMode) ([]byte, string, error) {
+	var rendered []byte
+	var err error
+	local := s.renderLocally
+	if s.format == FormatHTML {
+		rendered = s.passThroughHTML(input)
+	} else {
+		body, _, _ := s.frontMatter(input)
+		var math []string
+		if !s.noMath {
+			body, math = protectMath(body)
+		}
+		if rendered, local, err = s.renderMarkdown(body); err != nil {
+			return nil, "", err
+		}
+		rendered = restoreMath(rendered, math)
+	}
+	var issues []a11yIssue
+	var title string
+	if rendered, err = postProcess(rendered, append(s.transforms(path, input, mode, local, &issues), firstHeading(&title))...); err != nil {
+		return nil, "", err
+	}
+	if s.strict && mode == renderExport && len(issues) > 0 {
+		return nil, "", &a11yError{issues}
+	}
+	if _, front, ok := s.frontMatter(input); ok {
+		if t := frontMatterTitle(front); t != "" {
+			title = t
+		}
+	}
+	return rendered, title, nil
+}
+
+// renderMarkdown renders body with the configured renderer, reporting
+// whether it was rendered locally, including by an API render falling back.
+func (s *Server) renderMarkdown(body []byte) ([]byte, bool, error) {
+	if r, ok := s.renderer.(githubRenderer); ok {
+		return r.render(body)
+	}
+	rendered, err := s.renderer.Render(body)
+	return rendered, s.renderLocally, err
+}
+
+// transforms lists the rewrites applied to a render of input, the content of
+// the file at path, if any. local says whether it was rendered locally,
+// needing the emoji and highlighting GitHub adds to its renders.
+// Accessibility issues found are appended to issues.
+func (s *Server) transforms(path string, input []byte, mode renderMode, local bool, issues *[]a11yIssue) []transform {
+	live := mode == renderLive
+	var ts []transform
+	if s.format == FormatMarkdown {
+		// Source lines go first, before other transforms add elements. Front
+		// matter is blanked out of the body, so it isn't taken for blocks.
+		lines := sourceLines(input)
+		body, front, hasFront := s.frontMatter(input)
+		bodyLines := sourceLines(body)
+		ts = append(ts, annotateSourceLines(sourceBlocks(bodyLines)))
+		if live {
+			ts = append(ts, linkTasks(bodyLines, taskLines(bodyLines)))
+		}
+		if cover, ok := coverPage(front); hasFront && s.cover && ok {
+			ts = append(ts, cover)
+		} else if hasFront {
+			ts = append(ts, frontMatterTable(front))
+		}
+		if s.comments && path != "" {
+			s.commentsMu.Lock()
+			comments, err := loadComments(path)
+			s.commentsMu.Unlock()
+			if err != nil {
+				s.log.WithError(err).Warn("failed to load comments")
+			}
+			reanchor(comments, lines)
+			ts = append(ts, attachComments(comments, live))
+		}
+	}
+	if s.format == FormatMarkdown {
+		ts = append(ts, headingAnchors)
+	}
+	switch s.autolink {
+	case AutolinkOff:
+		// HTML files have no autolinks; every link in them is explicit.
+		if s.format == FormatMarkdown {
+			body, _, _ := s.frontMatter(input)
+			ts = append(ts, unlinkBareURLs(sourceLinks(body)))
+		}
+	case AutolinkWWW:
+		ts = append(ts, linkWWW)
+	}
+	if local && s.format == FormatMarkdown && !s.noEmoji {
+		ts = append(ts, s.emoji(live))
+	}
+	if s.smart && s.format == FormatMarkdown {
+		ts = append(ts, smartypants)
+	}
+	if s.format == FormatMarkdown {
+		ts = append(ts, renderMermaid)
+		if mode == renderExport {
+			ts = append(ts, s.drawMermaid())
+		}
+	}
+	if local && s.format == FormatMarkdown {
+		ts = append(ts, highlightCode(s.codeTheme))
+	}
+	if s.validateCode {
+		ts = append(ts, validateCode)
+	}
+	if s.a11y {
+		ts = append(ts, checkAccessibility(issues))
+	}
+	if live {
+		ts = append(ts, s.rewriteAssetURLs(path))
+	}
+	return ts
+}
 
-	return io.ReadAll(resp.Body)
+// printRendered copies rendered HTML to the debug output, if any.
+func (s *Server) printRendered(rendered []byte) {
+	if s.printOut == nil {
+		return
+	}
+	s.printMu.Lock()
+	defer s.printMu.Unlock()
+
+	if s.printLimit > 0 && len(rendered) > s.printLimit {
+		fmt.Fprintf(s.printOut, "%s\n[truncated %d of %d bytes]\n", rendered[:s.printLimit], len(rendered)-s.printLimit, len(rendered))
+		return
+	}
+	fmt.Fprintf(s.printOut, "%s\n", rendered)
 }
 
-func (s *Server) watcher(changes chan<- struct{}) {
+// watchFiles watches the previewed files, scheduling a render of each of
+// docs when its file changes.
+func (s *Server) watchFiles(docs []*document) {
 	w, err := fsnotify.NewWatcher()
 	if err != nil {
 		s.log.WithError(err).Error("failed to create file watcher")
@@ -154,19 +1052,29 @@ func (s *Server) watcher(changes chan<- struct{}) {
 	}
 	defer w.Close()
 
-	err = w.Add(s.path)
-	if err != nil {
-		s.log.WithError(err).Error("failed to watch file")
-		return
+	byPath := make(map[string]*document, len(docs))
+	for _, d := range docs {
+		if err := w.Add(d.path); err != nil {
+			s.log.WithError(err).Error("failed to watch file")
+			return
+		}
+		byPath[d.path] = d
 	}
+	s.addExtraWatches(w)
 
-	changes <- struct{}{} // Send initial render trigger
+	// While a file is missing, rewatch polls for it, and no renders of it
+	// are scheduled until it is back.
+	restored := make(chan *document)
+	missing := map[*document]bool{}
 
 	for {
 		select {
 		case <-s.ctx.Done():
 			s.log.Debug("watcher shutting down")
 			return
+		case d := <-restored:
+			delete(missing, d)
+			s.fileChanged(d)
 		case event, ok := <-w.Events:
 			if !ok {
 				return
@@ -176,19 +1084,29 @@ func (s *Server) watcher(changes chan<- struct{}) {
 				"event": event.Op,
 			}).Debug("file event")
 
+			if s.extraEvent(w, event) {
+				continue
+			}
+			d, ok := byPath[event.Name]
+			if !ok {
+				continue
+			}
 			switch event.Op {
 			case fsnotify.Remove, fsnotify.Rename:
-				// File was removed or renamed - try to re-add it after a delay
-				// This handles editor save patterns (write to temp, rename)
-				go func() {
-					time.Sleep(100 * time.Millisecond)
-					if err := w.Add(s.path); err != nil {
-						s.log.WithError(err).Debug("failed to re-add watch")
-					}
-				}()
-				changes <- struct{}{}
+				// Editors often save by writing a temporary file and
+				// renaming it over this one, which drops the watch.
+				d.invalidate()
+				if !missing[d] {
+					missing[d] = true
+					go s.rewatch(w, d, restored)
+				}
 			case fsnotify.Write, fsnotify.Chmod:
-				changes <- struct{}{}
+				// Deleting the file changes its link count just before the
+				// Remove event, which is left to handle it.
+				if _, err := os.Stat(d.path); errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				s.fileChanged(d)
 			}
 		case err, ok := <-w.Errors:
 			if !ok {
@@ -199,41 +1117,70 @@ func (s *Server) watcher(changes chan<- struct{}) {
 	}
 }
 
-func (s *Server) writer(ws *websocket.Conn) {
-	defer ws.Close()
+// renderMessage renders d for the preview. A failed render becomes an error
+// message, to tell the viewer why the preview stopped updating rather than
+// leave it silently stale.
+func (s *Server) renderMessage(d *document) wsMessage {
+	start := time.Now()
+	rendered, err := s.render(d, renderLive)
+	if err != nil {
+		s.log.WithError(err).Error("failed to render markdown")
+		return s.renderError(d, err)
+	}
+	msg := wsMessage{Type: "render", File: s.documentName(d.path), HTML: string(rendered)}
+	d.hashMu.Lock()
+	reading := d.reading
+	msg.Title = d.title
+	d.hashMu.Unlock()
+	msg.Reading = &reading
+	if s.diagnostics {
+		msg.Stats = d.stats.record(time.Since(start), len(rendered))
+	}
+	return msg
+}
 
-	pingInterval := 2 * time.Second
-	pingTicker := time.NewTicker(pingInterval)
-	defer pingTicker.Stop()
+// renderError describes a failed render of d to the viewer.
+func (s *Server) renderError(d *document, err error) wsMessage {
+	return wsMessage{Type: "error", File: s.documentName(d.path), Error: fmt.Sprintf("Failed to render %s: %v", filepath.Base(d.path), err)}
+}
 
-	changes := make(chan struct{}, 1) // Buffered to prevent blocking watcher
-	go s.watcher(changes)
+// writer forwards broadcasts to a client and keeps the connection alive
+// with pings.
+func (s *Server) writer(c *client) {
+	defer c.ws.Close()
+
+	pingTicker := time.NewTicker(s.pingInterval)
+	defer pingTicker.Stop()
 
 	for {
 		select {
 		case <-s.ctx.Done():
 			s.log.Debug("writer shutting down")
+			s.closeClient(c)
 			return
-		case <-changes:
-			rendered, err := s.render()
-			if err != nil {
-				s.log.WithError(err).Error("failed to render markdown")
-				continue
-			}
-			s.log.Debug("sending rendered content")
-			if err := ws.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		case m, ok := <-c.send:
+			if !ok {
+				// The hub dropped the client.
+				s.closeClient(c)
 				return
 			}
-			if err := ws.WriteMessage(websocket.TextMessage, rendered); err != nil {
+			s.log.Debug("sending rendered content")
+			start := time.Now()
+			if err := c.write(m.typ, m.data); err != nil {
 				s.log.WithError(err).Debug("failed to write message")
 				return
 			}
+			took := time.Since(start)
+			s.metrics.wrote(took, len(m.data))
+			if s.logStats {
+				s.log.WithFields(logrus.Fields{
+					"bytes":    len(m.data),
+					"write_ms": milliseconds(took),
+				}).Info("sent update")
+			}
 		case <-pingTicker.C:
 			s.log.Debug("sending ping")
-			if err := ws.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
-				return
-			}
-			if err := ws.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+			if err := c.write(websocket.PingMessage, []byte{}); err != nil {
 				s.log.WithError(err).Debug("failed to send ping")
 				return
 			}
@@ -241,34 +1188,65 @@ func (s *Server) writer(ws *websocket.Conn) {
 	}
 }
 
-func (s *Server) reader(ws *websocket.Conn) {
+// closeClient sends a close frame, so that the browser hangs up and the
+// reader's pending read returns straight away.
+func (s *Server) closeClient(c *client) {
+	code, text := websocket.CloseNormalClosure, ""
+	if s.ctx.Err() != nil {
+		code, text = websocket.CloseGoingAway, "server shutting down"
+	}
+	c.write(websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+}
+
+// encodeMessage prepares msg for the WebSocket. In binary mode the JSON is
+// gzipped behind a frameGzip header byte; otherwise it is sent as text.
+func (s *Server) encodeMessage(msg wsMessage) (message, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return message{}, err
+	}
+	if !s.binaryMessages {
+		return message{typ: websocket.TextMessage, data: payload}, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(frameGzip)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		return message{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return message{}, err
+	}
+	return message{typ: websocket.BinaryMessage, data: buf.Bytes()}, nil
+}
+
+func (s *Server) reader(c *client, d *document) {
+	ws := c.ws
 	defer ws.Close()
-	
-	ws.SetReadLimit(5 * 1024 * 1024) // 5MB limit for file content
-	
-	if err := ws.SetReadDeadline(time.Now().Add(60 * time.Second)); err != nil {
+
+	ws.SetReadLimit(maxContentSize)
+
+	if err := ws.SetReadDeadline(time.Now().Add(s.wsTimeout)); err != nil {
 		s.log.WithError(err).Error("failed to set read deadline")
 		return
 	}
-	
+
 	ws.SetPongHandler(func(string) error {
-		return ws.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return ws.SetReadDeadline(time.Now().Add(s.wsTimeout))
 	})
-	
+
 	// Send initial content
-	content, err := os.ReadFile(s.path)
+	content, err := s.readFile(d.path)
 	if err == nil {
-		msg := map[string]string{
-			"type":    "content",
-			"content": string(content),
-		}
+		msg := wsMessage{Type: "content", File: s.documentName(d.path), Content: string(content)}
 		if data, err := json.Marshal(msg); err == nil {
-			if err := ws.WriteMessage(websocket.TextMessage, data); err != nil {
+			if err := c.write(websocket.TextMessage, data); err != nil {
 				s.log.WithError(err).Error("failed to send initial content")
 			}
 		}
 	}
-	
+
 	for {
 		select {
 		case <-s.ctx.Done():
@@ -282,42 +1260,93 @@ func (s *Server) reader(ws *websocket.Conn) {
 				}
 				return
 			}
-			
+
 			// Parse message as JSON
-			var msg map[string]string
+			var msg wsMessage
 			if err := json.Unmarshal(message, &msg); err != nil {
 				s.log.WithError(err).Debug("failed to parse message")
 				continue
 			}
-			
+
 			// Handle different message types
-			switch msg["type"] {
-			case "save":
-				if err := s.saveContent(msg["content"]); err != nil {
+			switch msg.Type {
+			case "save", "toggle":
+				if !s.allowSave || c.readOnly {
+					reason := "Saving is disabled; start mdpreview with -allow-save to enable it"
+					if c.readOnly {
+						reason = "This shared preview is read-only"
+					}
+					response := wsMessage{Type: "error", Error: reason}
+					if data, err := json.Marshal(response); err == nil {
+						c.write(websocket.TextMessage, data)
+					}
+					break
+				}
+				if msg.Type == "toggle" {
+					// The client's checkbox already shows the change, but
+					// its editor needs the rewritten source, so it isn't
+					// marked saving.
+					if err := s.saveToggle(d.path, msg.Line, msg.Text, msg.Checked); err != nil {
+						s.log.WithError(err).Error("failed to toggle task")
+						response := wsMessage{Type: "error", Error: fmt.Sprintf("Failed to toggle task: %v", err)}
+						if data, err := json.Marshal(response); err == nil {
+							c.write(websocket.TextMessage, data)
+						}
+					}
+					break
+				}
+				d.saving(c, []byte(msg.Content))
+				if err := s.saveContent(d.path, msg.Content); err != nil {
 					s.log.WithError(err).Error("failed to save file")
 					// Send error back to client
-					response := map[string]string{
-						"type":  "error",
-						"error": "Failed to save file",
-					}
+					response := wsMessage{Type: "error", Error: "Failed to save file"}
 					if data, err := json.Marshal(response); err == nil {
-						ws.WriteMessage(websocket.TextMessage, data)
+						c.write(websocket.TextMessage, data)
 					}
 				} else {
 					s.log.Info("file saved successfully")
 				}
+			case "resync":
+				s.resync(c, d)
 			}
 		}
 	}
 }
 
-func (s *Server) saveContent(content string) error {
+// resync renders d and sends it straight to c, which has missed updates
+// while disconnected, whether or not the file changed since.
+func (s *Server) resync(c *client, d *document) {
+	m, err := s.encodeMessage(s.renderMessage(d))
+	if err != nil {
+		s.log.WithError(err).Error("failed to encode message")
+		return
+	}
+	if err := c.write(m.typ, m.data); err != nil {
+		s.log.WithError(err).Debug("failed to send resync")
+	}
+}
+
+// saveToggle checks or unchecks the task list item on line of the file at
+// path, if the line still reads text.
+func (s *Server) saveToggle(path string, line int, text string, checked bool) error {
+	input, err := s.readFile(path)
+	if err != nil {
+		return err
+	}
+	output, err := s.toggleTask(input, line, text, checked)
+	if err != nil {
+		return err
+	}
+	return s.saveContent(path, string(output))
+}
+
+func (s *Server) saveContent(path, content string) error {
 	// Write to a temporary file first, then rename (atomic operation)
-	tmpFile := s.path + ".tmp"
-	
+	tmpFile := path + ".tmp"
+
 	if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
 		return err
 	}
-	
-	return os.Rename(tmpFile, s.path)
+
+	return os.Rename(tmpFile, path)
 }
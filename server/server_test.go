@@ -0,0 +1,607 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestServer writes content to a file called name in a temporary
+// directory and returns a Server previewing it, rendering locally. The
+// server is shut down when the test ends.
+func newTestServer(t *testing.T, name, content string, opts Options) *Server {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return newTestServerFor(t, []string{path}, opts)
+}
+
+// newTestServerFor returns a Server previewing paths, rendering locally,
+// that is shut down when the test ends.
+func newTestServerFor(t *testing.T, paths []string, opts Options) *Server {
+	t.Helper()
+	opts.RenderLocally = true
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := New(ctx, paths, testLogger(), opts)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		wait, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		if err := s.Wait(wait); err != nil {
+			t.Errorf("server didn't shut down: %v", err)
+		}
+	})
+	return s
+}
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+// startTestServer runs s and serves it over HTTP until the test ends.
+func startTestServer(t *testing.T, s *Server) *httptest.Server {
+	t.Helper()
+	h, err := s.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// dialTestServer opens a WebSocket to ts, with query, such as "?file=a.md",
+// added to the URL.
+func dialTestServer(t *testing.T, ts *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// readMessage reads the next message from ws, text or binary.
+func readMessage(t *testing.T, ws *websocket.Conn) wsMessage {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	typ, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ == websocket.BinaryMessage {
+		if len(data) == 0 || data[0] != frameGzip {
+			t.Fatalf("binary message without gzip header: %q", data)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data[1:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var msg wsMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("invalid message %q: %v", data, err)
+	}
+	return msg
+}
+
+// readType reads messages from ws until one of type typ, skipping the
+// rest.
+func readType(t *testing.T, ws *websocket.Conn, typ string) wsMessage {
+	t.Helper()
+	for {
+		if msg := readMessage(t, ws); msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+func TestInitialRenderDelay(t *testing.T) {
+	const delay = 500 * time.Millisecond
+	start := time.Now()
+	s := newTestServer(t, "doc.md", "# Ready\n", Options{InitialRenderDelay: delay})
+	ws := dialTestServer(t, startTestServer(t, s), "")
+
+	if msg := readType(t, ws, "render"); msg.HTML != settlingHTML {
+		t.Fatalf("first render = %q, want the settling placeholder", msg.HTML)
+	}
+	msg := readType(t, ws, "render")
+	if took := time.Since(start); took < delay {
+		t.Errorf("document rendered after %s, before the %s delay", took, delay)
+	}
+	if !strings.Contains(msg.HTML, "Ready") {
+		t.Errorf("render = %q, want the document", msg.HTML)
+	}
+}
+
+func TestNoInitialRenderDelay(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Ready\n", Options{})
+	ws := dialTestServer(t, startTestServer(t, s), "")
+
+	if msg := readType(t, ws, "render"); !strings.Contains(msg.HTML, "Ready") {
+		t.Errorf("first render = %q, want the document", msg.HTML)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{AllowedOrigins: []string{"https://app.example"}})
+	ts := startTestServer(t, s)
+
+	for _, path := range []string{"/widget.js", "/github.css", "/content"} {
+		for origin, want := range map[string]string{
+			"https://app.example":  "https://app.example",
+			"https://evil.example": "",
+			"":                     "",
+		} {
+			req, err := http.NewRequest("GET", ts.URL+path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if origin != "" {
+				req.Header.Set("Origin", origin)
+			}
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("GET %s from %q: status %d", path, origin, resp.StatusCode)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != want {
+				t.Errorf("GET %s from %q: Access-Control-Allow-Origin = %q, want %q", path, origin, got, want)
+			}
+		}
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{AllowedOrigins: []string{"https://app.example"}})
+	ts := startTestServer(t, s)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	for origin, ok := range map[string]bool{
+		"https://app.example":  true,
+		ts.URL:                 true,
+		"https://evil.example": false,
+	} {
+		ws, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {origin}})
+		if ok {
+			if err != nil {
+				t.Errorf("WebSocket from %s refused: %v", origin, err)
+				continue
+			}
+			ws.Close()
+			continue
+		}
+		if err == nil {
+			ws.Close()
+			t.Errorf("WebSocket from %s accepted", origin)
+		} else if resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Errorf("WebSocket from %s: %v, want 403", origin, err)
+		}
+	}
+}
+
+func TestAnyOrigin(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{AllowedOrigins: []string{"*"}})
+	req := httptest.NewRequest("GET", "/content", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+	if !s.checkOrigin(req) {
+		t.Error("origin refused with * allowed")
+	}
+}
+
+func TestWebSocketTimeouts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.md")
+	if err := os.WriteFile(path, []byte("# Doc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := New(ctx, []string{path}, testLogger(), Options{PingInterval: time.Second, WSTimeout: time.Second}); err == nil {
+		t.Error("WebSocket timeout shorter than twice the ping interval accepted")
+	}
+
+	s := newTestServerFor(t, []string{path}, Options{})
+	if s.pingInterval != DefaultPingInterval || s.wsTimeout != DefaultWSTimeout || s.writeWait != DefaultWriteTimeout {
+		t.Errorf("timeouts = %s, %s, %s, want the defaults", s.pingInterval, s.wsTimeout, s.writeWait)
+	}
+
+	// Pings often enough keep a quiet connection open past the timeout.
+	s = newTestServerFor(t, []string{path}, Options{PingInterval: 20 * time.Millisecond, WSTimeout: 100 * time.Millisecond, WriteTimeout: 50 * time.Millisecond})
+	if s.writeWait != 50*time.Millisecond {
+		t.Errorf("write timeout = %s, want 50ms", s.writeWait)
+	}
+	ws := dialTestServer(t, startTestServer(t, s), "")
+	readConnected(t, ws)
+	ws.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	for {
+		_, _, err := ws.ReadMessage()
+		var timeout net.Error
+		if errors.As(err, &timeout) && timeout.Timeout() {
+			break
+		}
+		if err != nil {
+			t.Fatalf("connection dropped while answering pings: %v", err)
+		}
+	}
+}
+
+func TestReadFileRetriesTransientErrors(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{ReadRetries: 5, ReadBackoff: 20 * time.Millisecond})
+	// Reading a directory fails, but not because the file is missing, like
+	// a file caught mid-save.
+	if err := os.Remove(s.path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(s.path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	saved := make(chan error, 1)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		if err := os.Remove(s.path); err != nil {
+			saved <- err
+			return
+		}
+		saved <- os.WriteFile(s.path, []byte("# Saved\n"), 0644)
+	}()
+
+	content, err := s.readFile(s.path)
+	if err := <-saved; err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("read failed despite retries: %v", err)
+	}
+	if string(content) != "# Saved\n" {
+		t.Errorf("read %q, want the saved file", content)
+	}
+}
+
+func TestReadFileGivesUp(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{ReadRetries: 2, ReadBackoff: time.Millisecond})
+	if err := os.Remove(s.path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(s.path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.readFile(s.path); err == nil {
+		t.Error("reading a directory succeeded")
+	}
+}
+
+func TestReadFileMissingNotRetried(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{ReadRetries: 3, ReadBackoff: time.Second})
+	if err := os.Remove(s.path); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err := s.readFile(s.path)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error = %v, want it to be fs.ErrNotExist", err)
+	}
+	if took := time.Since(start); took >= time.Second {
+		t.Errorf("missing file reported after %s, retrying", took)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe to write from the server while the
+// test reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRenderOutput(t *testing.T) {
+	var out lockedBuffer
+	s := newTestServer(t, "doc.md", "# Printed\n", Options{RenderOutput: &out})
+	ws := dialTestServer(t, startTestServer(t, s), "")
+
+	msg := readType(t, ws, "render")
+	if got := out.String(); got != msg.HTML+"\n" {
+		t.Errorf("printed %q, want the render %q", got, msg.HTML)
+	}
+}
+
+func TestRenderOutputLimit(t *testing.T) {
+	var out lockedBuffer
+	s := newTestServer(t, "doc.md", "# Printed\n\n"+strings.Repeat("word ", 100)+"\n", Options{RenderOutput: &out, RenderOutputLimit: 20})
+	ws := dialTestServer(t, startTestServer(t, s), "")
+
+	msg := readType(t, ws, "render")
+	want := fmt.Sprintf("%s\n[truncated %d of %d bytes]\n", msg.HTML[:20], len(msg.HTML)-20, len(msg.HTML))
+	if got := out.String(); got != want {
+		t.Errorf("printed %q, want %q", got, want)
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	want := wsMessage{Type: "render", File: "doc.md", HTML: "<h1>Doc</h1>", Title: "Doc"}
+	for _, binary := range []bool{false, true} {
+		s := newTestServer(t, "doc.md", "# Doc\n", Options{BinaryMessages: binary})
+		m, err := s.encodeMessage(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := m.data
+		if binary {
+			if m.typ != websocket.BinaryMessage || data[0] != frameGzip {
+				t.Fatalf("binary mode sent message type %d, header %d", m.typ, data[0])
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(data[1:]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, err = io.ReadAll(zr); err != nil {
+				t.Fatal(err)
+			}
+		} else if m.typ != websocket.TextMessage {
+			t.Fatalf("text mode sent message type %d", m.typ)
+		}
+		var got wsMessage
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("binary %v: round trip gave %+v, want %+v", binary, got, want)
+		}
+	}
+}
+
+func TestBinaryMessages(t *testing.T) {
+	for _, binary := range []bool{false, true} {
+		s := newTestServer(t, "doc.md", "# Doc\n", Options{BinaryMessages: binary})
+		ws := dialTestServer(t, startTestServer(t, s), "")
+		for {
+			ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+			typ, data, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The initial content is always sent as text.
+			if typ == websocket.TextMessage && bytes.Contains(data, []byte(`"type":"content"`)) {
+				continue
+			}
+			want := websocket.TextMessage
+			if binary {
+				want = websocket.BinaryMessage
+			}
+			if typ != want {
+				t.Errorf("binary %v: render sent as message type %d, want %d", binary, typ, want)
+			}
+			break
+		}
+	}
+}
+
+// readConnected reads the messages a client is sent on connecting, the
+// file's content and its render in either order, returning the render.
+func readConnected(t *testing.T, ws *websocket.Conn) wsMessage {
+	t.Helper()
+	var render *wsMessage
+	content := false
+	for render == nil || !content {
+		switch msg := readMessage(t, ws); msg.Type {
+		case "content":
+			content = true
+		case "render":
+			render = &msg
+		}
+	}
+	return *render
+}
+
+// waitForRender reads messages from ws until a render containing want.
+func waitForRender(t *testing.T, ws *websocket.Conn, want string) wsMessage {
+	t.Helper()
+	for {
+		if msg := readType(t, ws, "render"); strings.Contains(msg.HTML, want) {
+			return msg
+		}
+	}
+}
+
+func TestShutdownWithOpenConnections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.md")
+	if err := os.WriteFile(path, []byte("# Doc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := New(ctx, []string{path}, testLogger(), Options{RenderLocally: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := startTestServer(t, s)
+	var clients []*websocket.Conn
+	for i := 0; i < 3; i++ {
+		ws := dialTestServer(t, ts, "")
+		readType(t, ws, "render")
+		clients = append(clients, ws)
+	}
+
+	start := time.Now()
+	cancel()
+	wait, done := context.WithTimeout(context.Background(), 10*time.Second)
+	defer done()
+	if err := s.Wait(wait); err != nil {
+		t.Fatalf("shutdown didn't complete: %v", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("shutdown took %s", took)
+	}
+	for _, ws := range clients {
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			_, _, err := ws.ReadMessage()
+			if err == nil {
+				continue
+			}
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("client closed with %v, want going away", err)
+			}
+			break
+		}
+	}
+}
+
+func TestResync(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{})
+	ts := startTestServer(t, s)
+	ws := dialTestServer(t, ts, "")
+	other := dialTestServer(t, ts, "")
+	first := readConnected(t, ws)
+	readConnected(t, other)
+
+	if err := ws.WriteJSON(wsMessage{Type: "resync"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readType(t, ws, "render"); msg.HTML != first.HTML {
+		t.Errorf("resync sent %q, want %q", msg.HTML, first.HTML)
+	}
+	// Only the client that asked is sent the render.
+	other.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, data, err := other.ReadMessage(); err == nil {
+		t.Errorf("other client was sent %s", data)
+	}
+}
+
+func TestContentOnly(t *testing.T) {
+	for _, contentOnly := range []bool{false, true} {
+		s := newTestServer(t, "doc.md", "# Doc\n", Options{ContentOnly: contentOnly})
+		ts := startTestServer(t, s)
+		ws := dialTestServer(t, ts, "")
+		readConnected(t, ws)
+
+		// Changing the mode only changes metadata.
+		if err := os.Chmod(s.path, 0600); err != nil {
+			t.Fatal(err)
+		}
+		ws.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		_, _, err := ws.ReadMessage()
+		if rendered := err == nil; rendered == contentOnly {
+			t.Errorf("content only %v: chmod rendered %v", contentOnly, rendered)
+		}
+		if err != nil {
+			// The read deadline broke the connection.
+			ws = dialTestServer(t, ts, "")
+			readConnected(t, ws)
+		}
+
+		if err := os.WriteFile(s.path, []byte("# Edited\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		waitForRender(t, ws, "Edited")
+	}
+}
+
+func TestContentOnlyRestore(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{ContentOnly: true})
+	ts := startTestServer(t, s)
+	ws := dialTestServer(t, ts, "")
+	readConnected(t, ws)
+
+	if err := os.Remove(s.path); err != nil {
+		t.Fatal(err)
+	}
+	readType(t, ws, "error")
+	// Restored as it was, as by git stash pop, the file is rendered again
+	// to clear the error.
+	if err := os.WriteFile(s.path, []byte("# Doc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForRender(t, ws, "Doc")
+
+	// Later viewers aren't told it is missing either.
+	late := dialTestServer(t, ts, "")
+	readConnected(t, late)
+	late.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	for {
+		var msg wsMessage
+		if err := late.ReadJSON(&msg); err != nil {
+			break
+		}
+		if msg.Type == "error" {
+			t.Errorf("error replayed after the file was restored: %s", msg.Error)
+		}
+	}
+}
+
+func TestDiagnosticsStats(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{Diagnostics: true})
+	ws := dialTestServer(t, startTestServer(t, s), "")
+	first := readConnected(t, ws)
+	if first.Stats == nil {
+		t.Fatal("render without stats under -diagnostics")
+	}
+	history := len(first.Stats.History)
+	for _, edit := range []string{"Second", "Third"} {
+		if err := os.WriteFile(s.path, []byte("# "+edit+"\n\n"+strings.Repeat("word ", 100)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		msg := waitForRender(t, ws, edit)
+		if msg.Stats == nil {
+			t.Fatalf("render of %q without stats", edit)
+		}
+		if msg.Stats.Bytes != len(msg.HTML) {
+			t.Errorf("stats give %d bytes for a %d byte render", msg.Stats.Bytes, len(msg.HTML))
+		}
+		got := msg.Stats.History
+		if len(got) <= history || got[len(got)-1] != msg.Stats.DurationMS {
+			t.Errorf("history %v doesn't grow and end with this render's %vms", got, msg.Stats.DurationMS)
+		}
+		history = len(got)
+	}
+}
+
+func TestNoDiagnosticsStats(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{})
+	ws := dialTestServer(t, startTestServer(t, s), "")
+	if msg := readConnected(t, ws); msg.Stats != nil {
+		t.Errorf("stats sent without -diagnostics: %+v", msg.Stats)
+	}
+}
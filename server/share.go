@@ -0,0 +1,149 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shareCookie carries a share link's grant to the requests its page makes,
+// such as for images and the WebSocket.
+const shareCookie = "mdpreview_share"
+
+type readOnlyKey struct{}
+
+// ShareQuery returns the query string of a share link to the document
+// named file, valid for ttl, such as "expires=1700000000&sig=...". Anyone
+// with the link can view that document, but not change it or open any
+// other, until it expires. file is as in the file query parameter and must
+// be given when previewing a directory or several files.
+func (s *Server) ShareQuery(file string, ttl time.Duration) (string, error) {
+	doc, err := s.documentNamed(file)
+	if err == nil && s.named() && file == "" {
+		err = errNoDocument
+	}
+	if err != nil {
+		return "", fmt.Errorf("can't share %q: %w", file, err)
+	}
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	q := url.Values{"expires": {expires}, "sig": {s.shareSignature(doc, expires)}}
+	if s.named() {
+		q.Set("file", file)
+	}
+	return q.Encode(), nil
+}
+
+// named reports whether documents are picked by the file query parameter.
+func (s *Server) named() bool {
+	return s.dir || s.panes()
+}
+
+func (s *Server) shareSignature(doc, expires string) string {
+	mac := hmac.New(sha256.New, s.shareKey)
+	mac.Write([]byte("mdpreview-share:" + doc + "\x00" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validShare reports whether sig signs the document named file until
+// expires and it hasn't passed yet, returning the document.
+func (s *Server) validShare(file, expires, sig string) (string, time.Time, bool) {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	doc, err := s.documentNamed(file)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	t := time.Unix(unix, 0)
+	return doc, t, time.Now().Before(t) && hmac.Equal([]byte(sig), []byte(s.shareSignature(doc, expires)))
+}
+
+// shareGrant returns the document r may view with the share link it
+// carries, or the cookie one sets. A link in the query sets the cookie for
+// the page's own requests.
+func (s *Server) shareGrant(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if len(s.shareKey) == 0 {
+		return "", false
+	}
+	link := r.URL.Query()
+	if link.Get("sig") == "" {
+		link = nil
+		if cookie, err := r.Cookie(shareCookie); err == nil {
+			link, _ = url.ParseQuery(cookie.Value)
+		}
+	}
+	file, expires, sig := link.Get("file"), link.Get("expires"), link.Get("sig")
+	doc, until, ok := s.validShare(file, expires, sig)
+	if !ok {
+		return "", false
+	}
+	if r.URL.Query().Get("sig") != "" {
+		v := url.Values{"expires": {expires}, "sig": {sig}}
+		if file != "" {
+			v.Set("file", file)
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     shareCookie,
+			Value:    v.Encode(),
+			Path:     "/",
+			Expires:  until,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return doc, true
+}
+
+// shareAllows reports whether a share link to doc lets r through: to the
+// document's page, updates, source and comments, to the static files, and
+// to the files its render links to or shows, other than documents. Other
+// files next to it, such as dotfiles, aren't shared. Other documents, the
+// listing, /render and /metrics are refused.
+func (s *Server) shareAllows(r *http.Request, doc string) bool {
+	p := r.URL.Path
+	switch {
+	case p == s.route || p == "/ws" || p == "/content" || p == "/print" || p == "/comments":
+		// Without a file, the page lists every document or shows them all.
+		if s.named() && r.URL.Query().Get("file") == "" {
+			return false
+		}
+		requested, err := s.documentPath(r)
+		return err == nil && requested == doc
+	case strings.HasPrefix(p, assetsPrefix):
+		root, err := s.assetRoot(r)
+		if err != nil {
+			return false
+		}
+		full := filepath.Join(root, filepath.FromSlash(path.Clean("/"+strings.TrimPrefix(p, assetsPrefix))))
+		return s.referencedAsset(doc, full) && !isDocument(full)
+	case p == "/render" || p == "/metrics":
+		return false
+	}
+	return true
+}
+
+// readOnly reports whether r came through a share link, which may view but
+// not change anything.
+func readOnly(r *http.Request) bool {
+	v, _ := r.Context().Value(readOnlyKey{}).(bool)
+	return v
+}
+
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// shareOptions require credentials of every request, so that test requests,
+// which come from the loopback address, are let in only by a share link.
+var shareOptions = Options{ShareKey: []byte("secret"), BasicAuth: "user:pass"}
+
+// shareClient returns a client keeping the cookie a share link sets.
+func shareClient(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar}
+}
+
+// getStatus sends a request to url with client, returning the status.
+func getStatus(t *testing.T, client *http.Client, method, url string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(`{"markdown":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestShareLink(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", shareOptions)
+	ts := startTestServer(t, s)
+	query, err := s.ShareQuery("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, _ := url.ParseQuery(query)
+
+	tampered := url.Values{"expires": link["expires"], "sig": {"x" + link.Get("sig")}}
+	later := url.Values{"expires": {"99999999999"}, "sig": link["sig"]}
+	for q, want := range map[string]int{
+		"":                 http.StatusUnauthorized,
+		query:              http.StatusOK,
+		tampered.Encode():  http.StatusUnauthorized,
+		later.Encode():     http.StatusUnauthorized,
+		"file=x&" + query:  http.StatusOK,
+		"expires=&sig=abc": http.StatusUnauthorized,
+	} {
+		if got := getStatus(t, http.DefaultClient, "GET", ts.URL+"/?"+q); got != want {
+			t.Errorf("GET /?%s: status %d, want %d", q, got, want)
+		}
+	}
+
+	// The page's own requests carry the link in a cookie.
+	client := shareClient(t)
+	if got := getStatus(t, client, "GET", ts.URL+"/?"+query); got != http.StatusOK {
+		t.Fatalf("GET the shared page: status %d", got)
+	}
+	for path, want := range map[string]int{
+		"/github.css": http.StatusOK,
+		"/content":    http.StatusOK,
+		"/metrics":    http.StatusForbidden,
+	} {
+		if got := getStatus(t, client, "GET", ts.URL+path); got != want {
+			t.Errorf("GET %s with the link's cookie: status %d, want %d", path, got, want)
+		}
+	}
+	if got := getStatus(t, client, "POST", ts.URL+"/render"); got != http.StatusForbidden {
+		t.Errorf("POST /render with the link's cookie: status %d, want 403", got)
+	}
+	if got := getStatus(t, http.DefaultClient, "GET", ts.URL+"/github.css"); got != http.StatusUnauthorized {
+		t.Errorf("GET /github.css without the link: status %d, want 401", got)
+	}
+}
+
+func TestShareLinkExpired(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", shareOptions)
+	ts := startTestServer(t, s)
+	query, err := s.ShareQuery("", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := getStatus(t, http.DefaultClient, "GET", ts.URL+"/?"+query); got != http.StatusUnauthorized {
+		t.Errorf("GET with an expired link: status %d, want 401", got)
+	}
+	if _, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?"+query, nil); err == nil {
+		t.Error("WebSocket opened with an expired link")
+	}
+}
+
+func TestShareLinkDocument(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.md": "# A\n\n![Shot](shot.png)\n", "b.md": "# B\n", "shot.png": "png"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newTestServerFor(t, []string{dir}, shareOptions)
+	ts := startTestServer(t, s)
+	if _, err := s.ShareQuery("", time.Hour); err == nil {
+		t.Error("shared a directory without naming a document")
+	}
+	query, err := s.ShareQuery("a.md", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A link to one document isn't one to another.
+	other := strings.Replace(query, "file=a.md", "file=b.md", 1)
+	if got := getStatus(t, http.DefaultClient, "GET", ts.URL+"/?"+other); got != http.StatusUnauthorized {
+		t.Errorf("GET b.md with a link to a.md: status %d, want 401", got)
+	}
+
+	client := shareClient(t)
+	if got := getStatus(t, client, "GET", ts.URL+"/?"+query); got != http.StatusOK {
+		t.Fatalf("GET the shared document: status %d", got)
+	}
+	readConnected(t, dialTestServer(t, ts, "?"+query))
+	for path, want := range map[string]int{
+		"/ws?file=a.md":      http.StatusBadRequest, // not a WebSocket handshake, but let through
+		"/content?file=a.md": http.StatusOK,
+		"/assets/shot.png":   http.StatusOK,
+		"/content?file=b.md": http.StatusForbidden,
+		"/?file=b.md":        http.StatusForbidden,
+		"/assets/b.md":       http.StatusForbidden,
+		"/":                  http.StatusForbidden,
+	} {
+		if got := getStatus(t, client, "GET", ts.URL+path); got != want {
+			t.Errorf("GET %s with a link to a.md: status %d, want %d", path, got, want)
+		}
+	}
+}
+
+func TestShareLinkAssets(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n\n![Shot](shot.png)\n", shareOptions)
+	dir := filepath.Dir(s.path)
+	for name, content := range map[string]string{
+		"shot.png":             "png",
+		"notes.txt":            "notes",
+		".env":                 "TOKEN=secret",
+		".git/config":          "[core]",
+		"doc.md.comments.json": "[]",
+	} {
+		full := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts := startTestServer(t, s)
+	query, err := s.ShareQuery("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := shareClient(t)
+	if got := getStatus(t, client, "GET", ts.URL+"/?"+query); got != http.StatusOK {
+		t.Fatalf("GET the shared page: status %d", got)
+	}
+	ws := dialTestServer(t, ts, "?"+query)
+	readConnected(t, ws)
+
+	// Only the files the document shows or links to are shared.
+	for path, want := range map[string]int{
+		"/assets/shot.png":             http.StatusOK,
+		"/assets/notes.txt":            http.StatusForbidden,
+		"/assets/.env":                 http.StatusForbidden,
+		"/assets/.git/config":          http.StatusForbidden,
+		"/assets/doc.md.comments.json": http.StatusForbidden,
+	} {
+		if got := getStatus(t, client, "GET", ts.URL+path); got != want {
+			t.Errorf("GET %s with the link's cookie: status %d, want %d", path, got, want)
+		}
+	}
+
+	if err := os.WriteFile(s.path, []byte("# Changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForRender(t, ws, "Changed")
+	if got := getStatus(t, client, "GET", ts.URL+"/assets/shot.png"); got != http.StatusForbidden {
+		t.Errorf("GET an image no longer shown with the link's cookie: status %d, want 403", got)
+	}
+}
+
+func TestShareLinkReadOnly(t *testing.T) {
+	opts := shareOptions
+	opts.AllowSave = true
+	opts.Comments = true
+	s := newTestServer(t, "doc.md", "# Doc\n", opts)
+	ts := startTestServer(t, s)
+	query, err := s.ShareQuery("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws := dialTestServer(t, ts, "?"+query)
+	readConnected(t, ws)
+	if err := ws.WriteJSON(wsMessage{Type: "save", Content: "# Changed\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readType(t, ws, "error"); !strings.Contains(msg.Error, "read-only") {
+		t.Errorf("save through a share link answered %q", msg.Error)
+	}
+	if content, _ := os.ReadFile(s.path); string(content) != "# Doc\n" {
+		t.Errorf("save through a share link wrote %q", content)
+	}
+
+	resp, err := http.Post(ts.URL+"/comments?"+query, "application/json", strings.NewReader(`{"line":1,"text":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("comment through a share link: status %d, want 403", resp.StatusCode)
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// verbatimElements hold text that typographic substitutions must not touch.
+var verbatimElements = map[string]bool{
+	"code":   true,
+	"pre":    true,
+	"kbd":    true,
+	"samp":   true,
+	"script": true,
+	"style":  true,
+}
+
+var dashes = strings.NewReplacer("---", "—", "--", "–", "...", "…")
+
+// smartypants curls straight quotes and turns -- and --- into en and em
+// dashes and ... into an ellipsis, outside of code, math and URLs.
+func smartypants(root *html.Node) {
+	// prev is the character before the current text node, to tell opening
+	// quotes from closing ones across inline elements.
+	prev := ' '
+	walk(root, func(n *html.Node) bool {
+		switch n.Type {
+		case html.ElementNode:
+			if class, _ := attr(n, "class"); verbatimElements[n.Data] || strings.HasPrefix(class, "math") {
+				prev = 'x'
+				return false
+			}
+			if n.Data == "p" || n.Data == "li" || n.Data == "br" || headingLevel(n) > 0 {
+				prev = ' '
+			}
+		case html.TextNode:
+			n.Data = smartText(n.Data, prev)
+			if r, _ := utf8.DecodeLastRuneInString(n.Data); r != utf8.RuneError {
+				prev = r
+			}
+		}
+		return true
+	})
+}
+
+// urlSpan matches a URL in text, with a scheme or starting www., without
+// the punctuation that may follow it.
+var urlSpan = regexp.MustCompile(`\b(?:(?:https?|ftp)://|www\.)[^\s<>]*[^\s<>.,:;!?'")\]*_~]`)
+
+// smartText substitutes the text of one node, which follows prev. URLs in
+// it are left as written.
+func smartText(s string, prev rune) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range urlSpan.FindAllStringIndex(s, -1) {
+		b.WriteString(smartSpan(s[last:loc[0]], prev))
+		b.WriteString(s[loc[0]:loc[1]])
+		prev, _ = utf8.DecodeLastRuneInString(s[:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(smartSpan(s[last:], prev))
+	return b.String()
+}
+
+// smartSpan substitutes text without URLs, which follows prev.
+func smartSpan(s string, prev rune) string {
+	s = dashes.Replace(s)
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '"':
+			if opensQuote(prev) {
+				b.WriteRune('“')
+			} else {
+				b.WriteRune('”')
+			}
+		case '\'':
+			if opensQuote(prev) {
+				b.WriteRune('‘')
+			} else {
+				// Also apostrophes, as in don't.
+				b.WriteRune('’')
+			}
+		default:
+			b.WriteRune(r)
+		}
+		prev = r
+	}
+	return b.String()
+}
+
+// opensQuote reports whether a quote after r opens a quotation.
+func opensQuote(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune("([{—–", r)
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSmartText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`"Quoted," she said -- and 'then' --- paused...`, `“Quoted,” she said – and ‘then’ — paused…`},
+		{`don't`, `don’t`},
+		{`see https://example.com/a--b...c and www.example.org/x--y -- done`, `see https://example.com/a--b...c and www.example.org/x--y – done`},
+		{`(https://example.com/it's--here) "after"`, `(https://example.com/it's--here) “after”`},
+		{`https://example.com/a--b.`, `https://example.com/a--b.`},
+	}
+	for _, tt := range tests {
+		if got := smartText(tt.in, ' '); got != tt.want {
+			t.Errorf("smartText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSmart(t *testing.T) {
+	const src = "\"Hello\" -- it's a test... see https://example.com/a--b for more.\n\n" +
+		"Inline `a -- \"b\"` code and $x -- y$ math.\n\n" +
+		"```\nfenced -- \"code\"...\n```\n"
+	smart := newTestServer(t, "doc.md", src, Options{Smart: true})
+	rendered, err := smart.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"“Hello” – it’s a test… see",
+		">https://example.com/a--b</a>",
+		`<code>a -- &#34;b&#34;</code>`,
+		"$x -- y$",
+		"fenced -- &#34;code&#34;...",
+	} {
+		if !strings.Contains(string(rendered), want) {
+			t.Errorf("-smart render lacks %s:\n%s", want, rendered)
+		}
+	}
+
+	plain := newTestServer(t, "doc.md", src, Options{})
+	if rendered, err = plain.Render(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rendered), "&#34;Hello&#34; -- it&#39;s a test... see") {
+		t.Errorf("render without -smart changed the text:\n%s", rendered)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	atxHeading  = regexp.MustCompile(`^ {0,3}#{1,6}(\s|$)`)
+	fenceOpen   = regexp.MustCompile("^ {0,3}(```+|~~~+)")
+	thematic    = regexp.MustCompile(`^ {0,3}((\* *){3,}|(- *){3,}|(_ *){3,})$`)
+	listItem    = regexp.MustCompile(`^ {0,3}([-*+]|\d+[.)])(\s|$)`)
+	linkDef     = regexp.MustCompile(`^ {0,3}\[[^\]]+\]:\s`)
+	indentedRow = regexp.MustCompile(`^( {4}|\t)`)
+)
+
+// sourceLines splits Markdown source into lines.
+func sourceLines(src []byte) []string {
+	return strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n")
+}
+
+// sourceBlocks returns the 1-based first line of each top-level block of
+// Markdown source, in document order. It knows the block rules well enough
+// to line up with the rendered top-level elements of typical documents but
+// is not a full parser, so the mapping is best effort.
+func sourceBlocks(lines []string) []int {
+	var starts []int
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case linkDef.MatchString(line):
+			// Link reference definitions render nothing.
+			i++
+		case fenceOpen.MatchString(line):
+			starts = append(starts, i+1)
+			i = skipFence(lines, i)
+		case atxHeading.MatchString(line), thematic.MatchString(line):
+			starts = append(starts, i+1)
+			i++
+		case listItem.MatchString(line):
+			starts = append(starts, i+1)
+			i = skipList(lines, i)
+		case indentedRow.MatchString(line):
+			starts = append(starts, i+1)
+			i = skipIndented(lines, i)
+		default:
+			// Paragraphs, including setext headings and tables, quotes and
+			// HTML blocks all run to the next blank line.
+			starts = append(starts, i+1)
+			i = skipParagraph(lines, i)
+		}
+	}
+	return starts
+}
+
+// skipFence returns the line after the fenced block opening at i.
+func skipFence(lines []string, i int) int {
+	fence := fenceOpen.FindStringSubmatch(lines[i])[1]
+	for i++; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+			return i + 1
+		}
+	}
+	return i
+}
+
+// skipList returns the line after the list starting at i. Blank lines stay
+// inside the list when followed by another item or an indented line.
+func skipList(lines []string, i int) int {
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			next := i + 1
+			for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+				next++
+			}
+			if next == len(lines) || !(listItem.MatchString(lines[next]) || indentedRow.MatchString(lines[next])) {
+				return i
+			}
+			i = next - 1
+			continue
+		}
+		if atxHeading.MatchString(line) || fenceOpen.MatchString(line) || (thematic.MatchString(line) && !listItem.MatchString(line)) {
+			return i
+		}
+	}
+	return i
+}
+
+// skipIndented returns the line after the indented code block starting at i.
+func skipIndented(lines []string, i int) int {
+	for i++; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != "" && !indentedRow.MatchString(lines[i]) {
+			return i
+		}
+	}
+	return i
+}
+
+// skipParagraph returns the line after the paragraph starting at i.
+func skipParagraph(lines []string, i int) int {
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" || atxHeading.MatchString(line) || fenceOpen.MatchString(line) {
+			return i
+		}
+	}
+	return i
+}
+
+// annotateSourceLines returns a transform that tags each top-level rendered
+// element with the source line its block starts on, as data-source-line.
+func annotateSourceLines(starts []int) transform {
+	return func(root *html.Node) {
+		i := 0
+		for n := root.FirstChild; n != nil && i < len(starts); n = n.NextSibling {
+			if n.Type != html.ElementNode {
+				continue
+			}
+			setAttr(n, "data-source-line", strconv.Itoa(starts[i]))
+			i++
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// staticCacheControl has browsers ask again before every use of a bundled
+// static file, whose URL doesn't change between releases, so that an
+// upgrade never runs old scripts against the new server. The ETag makes
+// asking cheap: an unchanged file is answered 304 Not Modified.
+const staticCacheControl = "no-cache"
+
+// inlineScript makes js safe to put in a <script> element: a "</script"
+// in it would end the element, so its slash is escaped, which means the
+// same in JavaScript strings and regular expressions.
+func inlineScript(js []byte) template.JS {
+	return template.JS(scriptEnd.ReplaceAllString(string(js), `<\/$1`))
+}
+
+var scriptEnd = regexp.MustCompile(`(?i)</(script)`)
+
+// staticHandler serves the static files: the bundled ones, cached by
+// browsers under content-hash ETags, or in dev mode those in staticDir on
+// disk, uncached so that edits show on reload.
+func (s *Server) staticHandler() http.Handler {
+	if s.staticDir != "" {
+		files := http.FileServer(http.FS(os.DirFS(s.staticDir)))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-store")
+			files.ServeHTTP(w, r)
+		})
+	}
+
+	root, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		// The embedded tree always contains static/.
+		panic(err)
+	}
+	etags := staticETags(root)
+	files := http.FileServer(http.FS(root))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if etag, ok := etags[strings.TrimPrefix(path.Clean(r.URL.Path), "/")]; ok {
+			// FileServer answers If-None-Match from the ETag header.
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", staticCacheControl)
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// staticETags hashes every file in root, by path.
+func staticETags(root fs.FS) map[string]string {
+	etags := map[string]string{}
+	fs.WalkDir(root, ".", func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(root, p)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		etags[p] = `"` + hex.EncodeToString(sum[:8]) + `"`
+		return nil
+	})
+	return etags
+}
@@ -0,0 +1,49 @@
+(function () {
+    // Review comments: Alt+click a block of the preview to comment on it,
+    // and use a comment's delete button to remove it. The server re-renders
+    // the preview with the comments in place after every change.
+    var preview = document.getElementById("preview");
+    var base = document.documentElement.dataset.base || '/';
+
+    function request(method, path, body) {
+        // The query names the file when previewing a directory.
+        return fetch(base + path + window.location.search, {
+            method: method,
+            headers: body ? { 'Content-Type': 'application/json' } : {},
+            body: body ? JSON.stringify(body) : undefined
+        }).then(function (resp) {
+            if (!resp.ok) {
+                return resp.text().then(function (text) {
+                    throw new Error(text || resp.statusText);
+                });
+            }
+        });
+    }
+
+    preview.addEventListener('click', function (event) {
+        var del = event.target.closest('.comment-delete');
+        if (del) {
+            var id = del.parentNode.dataset.commentId;
+            request('DELETE', 'comments/' + encodeURIComponent(id)).catch(function (err) {
+                alert('Could not delete comment: ' + err.message);
+            });
+            return;
+        }
+        if (!event.altKey) {
+            return;
+        }
+        var block = event.target.closest('[data-source-line]');
+        if (!block || !preview.contains(block)) {
+            return;
+        }
+        event.preventDefault();
+        var line = parseInt(block.dataset.sourceLine, 10);
+        var text = prompt('Comment on line ' + line);
+        if (!text || !text.trim()) {
+            return;
+        }
+        request('POST', 'comments', { line: line, text: text }).catch(function (err) {
+            alert('Could not save comment: ' + err.message);
+        });
+    });
+})()
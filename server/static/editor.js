@@ -1,262 +1,88 @@
 (function () {
-    let editor;
-    let ws;
-    let isConnected = false;
-    let isDirty = false;
-    let saveTimeout;
-    let currentMode = 'wysiwyg';
-    let isDarkMode = window.matchMedia('(prefers-color-scheme: dark)').matches;
-
-    // Initialize Toast UI Editor
-    function initEditor() {
-        editor = new toastui.Editor({
-            el: document.querySelector('#editor'),
-            height: '100%',
-            initialEditType: 'wysiwyg',
-            previewStyle: 'vertical',
-            usageStatistics: false,
-            toolbarItems: [
-                ['heading', 'bold', 'italic', 'strike'],
-                ['hr', 'quote'],
-                ['ul', 'ol', 'task', 'indent', 'outdent'],
-                ['table', 'link', 'image'],
-                ['code', 'codeblock'],
-                ['scrollSync'],
-            ],
-            hooks: {
-                addImageBlobHook: function (blob, callback) {
-                    // Handle image upload - for now just show data URL
-                    const reader = new FileReader();
-                    reader.onload = function(e) {
-                        callback(e.target.result, 'Image');
-                    };
-                    reader.readAsDataURL(blob);
-                }
-            },
-            customHTMLRenderer: {
-                // Add KaTeX rendering support
-                htmlBlock: {
-                    math(node) {
-                        const latex = node.literal;
-                        try {
-                            return [
-                                { type: 'openTag', tagName: 'div', outerNewLine: true, attributes: { class: 'math-block' } },
-                                { type: 'html', content: katex.renderToString(latex, { throwOnError: false, displayMode: true }) },
-                                { type: 'closeTag', tagName: 'div', outerNewLine: true }
-                            ];
-                        } catch (e) {
-                            return [
-                                { type: 'text', content: latex }
-                            ];
-                        }
-                    }
-                },
-                htmlInline: {
-                    math(node) {
-                        const latex = node.literal;
-                        try {
-                            return [
-                                { type: 'html', content: katex.renderToString(latex, { throwOnError: false, displayMode: false }) }
-                            ];
-                        } catch (e) {
-                            return [
-                                { type: 'text', content: latex }
-                            ];
-                        }
-                    }
-                }
-            }
-        });
-
-        // Listen for changes
-        editor.on('change', () => {
-            isDirty = true;
-            showSaveIndicator('Unsaved changes...', false);
-            
-            // Auto-save after 1 second of inactivity
-            clearTimeout(saveTimeout);
-            saveTimeout = setTimeout(() => {
-                saveFile();
-            }, 1000);
-        });
-
-        // Initialize WebSocket connection
-        initWebSocket();
+    // Source editor, loaded when the server accepts saves: the Edit button
+    // or Ctrl/Cmd+E shows the file's Markdown beside the preview. Edits are
+    // saved a second after typing stops, or at once with Ctrl/Cmd+S, and
+    // the preview follows from the server's render of the save.
+    var saveDelay = 1000;
+    var preview = document.getElementById('preview');
+    // dirty marks edits not sent to the server yet, which content from the
+    // server mustn't overwrite.
+    var dirty = false;
+    var timer;
+
+    // Until the content arrives there is nothing to edit.
+    var editor = document.createElement('textarea');
+    editor.className = 'source-editor';
+    editor.spellcheck = false;
+    editor.readOnly = true;
+    editor.hidden = true;
+    editor.setAttribute('aria-label', 'Markdown source');
+    preview.parentNode.insertBefore(editor, preview);
+
+    var toggle = document.createElement('button');
+    toggle.type = 'button';
+    toggle.className = 'edit-toggle';
+    toggle.textContent = 'Edit';
+    document.body.appendChild(toggle);
+
+    // The server sends the file's content on connect and after every change
+    // but our own saves.
+    window.mdpreviewLoadSource = function (content) {
+        if (!dirty) {
+            editor.value = content;
+        }
+        editor.readOnly = false;
+    };
+    if (window.mdpreviewSource !== undefined) {
+        window.mdpreviewLoadSource(window.mdpreviewSource);
     }
 
-    function initWebSocket() {
-        const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-        const wsUrl = protocol + '//' + window.location.host + '/ws';
-        
-        ws = new WebSocket(wsUrl);
-
-        ws.onopen = function () {
-            isConnected = true;
-            updateStatus(true);
-            console.log('WebSocket connected');
-        };
-
-        ws.onclose = function () {
-            isConnected = false;
-            updateStatus(false);
-            console.log('WebSocket disconnected');
-            
-            // Attempt to reconnect after 3 seconds
-            setTimeout(() => {
-                console.log('Attempting to reconnect...');
-                initWebSocket();
-            }, 3000);
-        };
-
-        ws.onerror = function (error) {
-            console.error('WebSocket error:', error);
-            updateStatus(false);
-        };
-
-        ws.onmessage = function (event) {
-            const message = event.data;
-            
-            try {
-                // Try to parse as JSON first
-                const data = JSON.parse(message);
-                
-                if (data.type === 'content') {
-                    // Initial content load
-                    if (editor && !isDirty) {
-                        editor.setMarkdown(data.content);
-                    }
-                } else if (data.type === 'error') {
-                    console.error('Server error:', data.error);
-                    showSaveIndicator('Error: ' + data.error, false);
-                }
-            } catch (e) {
-                // Not JSON, ignore (we're in WYSIWYG mode, don't need HTML preview)
-                console.debug('Received non-JSON message (likely preview HTML)');
-            }
-        };
+    function save() {
+        clearTimeout(timer);
+        // Unsent edits stay dirty and go with the next save.
+        if (dirty && window.mdpreviewSend({ type: 'save', content: editor.value })) {
+            dirty = false;
+        }
     }
 
-    function updateStatus(connected) {
-        const dot = document.getElementById('statusDot');
-        const text = document.getElementById('statusText');
-        
-        if (connected) {
-            dot.classList.remove('disconnected');
-            text.textContent = 'Connected';
+    function setEditing(on) {
+        editor.hidden = !on;
+        document.body.classList.toggle('editing', on);
+        toggle.textContent = on ? 'Close editor' : 'Edit';
+        if (on) {
+            editor.focus();
         } else {
-            dot.classList.add('disconnected');
-            text.textContent = 'Disconnected';
+            save();
         }
     }
 
-    function showSaveIndicator(message, isSuccess = true) {
-        const indicator = document.getElementById('saveIndicator');
-        const icon = indicator.querySelector('span:first-child');
-        const text = indicator.querySelector('span:last-child');
-        
-        icon.textContent = isSuccess ? '✓' : '⚠';
-        text.textContent = message;
-        indicator.classList.add('visible');
-    }
+    editor.addEventListener('input', function () {
+        dirty = true;
+        clearTimeout(timer);
+        timer = setTimeout(save, saveDelay);
+    });
 
-    function hideSaveIndicator() {
-        const indicator = document.getElementById('saveIndicator');
-        setTimeout(() => {
-            indicator.classList.remove('visible');
-        }, 2000);
-    }
+    toggle.addEventListener('click', function () {
+        setEditing(editor.hidden);
+    });
 
-    window.saveFile = function() {
-        if (!editor || !isConnected) {
-            showSaveIndicator('Not connected', false);
+    document.addEventListener('keydown', function (event) {
+        if (!(event.ctrlKey || event.metaKey) || event.altKey || event.shiftKey) {
             return;
         }
-        
-        const content = editor.getMarkdown();
-        
-        // Send save request via WebSocket
-        ws.send(JSON.stringify({
-            type: 'save',
-            content: content
-        }));
-        
-        isDirty = false;
-        showSaveIndicator('Saved', true);
-        hideSaveIndicator();
-    };
-
-    window.switchMode = function(mode) {
-        if (!editor) return;
-        
-        currentMode = mode;
-        
-        // Update UI
-        document.querySelectorAll('.mode-toggle button').forEach(btn => {
-            if (btn.dataset.mode === mode) {
-                btn.classList.add('active');
-            } else {
-                btn.classList.remove('active');
-            }
-        });
-        
-        // Switch editor mode
-        if (mode === 'wysiwyg') {
-            editor.changeMode('wysiwyg');
-        } else {
-            editor.changeMode('markdown');
-        }
-    };
-
-    window.toggleTheme = function() {
-        isDarkMode = !isDarkMode;
-        const icon = document.getElementById('themeIcon');
-        
-        if (isDarkMode) {
-            document.documentElement.style.setProperty('--bg-primary', '#0d1117');
-            document.documentElement.style.setProperty('--bg-secondary', '#161b22');
-            document.documentElement.style.setProperty('--border-color', '#30363d');
-            document.documentElement.style.setProperty('--text-primary', '#e6edf3');
-            document.documentElement.style.setProperty('--text-secondary', '#8b949e');
-            document.documentElement.style.setProperty('--editor-bg', '#0d1117');
-            icon.textContent = '☀️';
-        } else {
-            document.documentElement.style.setProperty('--bg-primary', '#ffffff');
-            document.documentElement.style.setProperty('--bg-secondary', '#f6f8fa');
-            document.documentElement.style.setProperty('--border-color', '#d0d7de');
-            document.documentElement.style.setProperty('--text-primary', '#24292f');
-            document.documentElement.style.setProperty('--text-secondary', '#57606a');
-            document.documentElement.style.setProperty('--editor-bg', '#ffffff');
-            icon.textContent = '🌙';
-        }
-    };
-
-    // Keyboard shortcuts
-    document.addEventListener('keydown', (e) => {
-        // Ctrl/Cmd + S to save
-        if ((e.ctrlKey || e.metaKey) && e.key === 's') {
-            e.preventDefault();
-            saveFile();
-        }
-        
-        // Ctrl/Cmd + Shift + M to toggle mode
-        if ((e.ctrlKey || e.metaKey) && e.shiftKey && e.key === 'M') {
-            e.preventDefault();
-            switchMode(currentMode === 'wysiwyg' ? 'markdown' : 'wysiwyg');
+        if (event.key === 'e') {
+            event.preventDefault();
+            setEditing(editor.hidden);
+        } else if (event.key === 's' && !editor.hidden) {
+            event.preventDefault();
+            save();
         }
     });
 
-    // Warn before leaving if there are unsaved changes
-    window.addEventListener('beforeunload', (e) => {
-        if (isDirty) {
-            e.preventDefault();
-            e.returnValue = '';
+    window.addEventListener('beforeunload', function (event) {
+        if (dirty) {
+            event.preventDefault();
+            event.returnValue = '';
         }
     });
-
-    // Initialize when DOM is ready
-    if (document.readyState === 'loading') {
-        document.addEventListener('DOMContentLoaded', initEditor);
-    } else {
-        initEditor();
-    }
-})();
\ No newline at end of file
+})()
@@ -0,0 +1,18 @@
+(function () {
+    // Renders the math spans of a render, <span class="math inline"> and
+    // <span class="math display"> holding the TeX with its $ delimiters,
+    // with KaTeX. Without KaTeX the TeX stays as written.
+    function renderMath(root) {
+        if (!window.katex) {
+            return;
+        }
+        root.querySelectorAll('.math').forEach(function (el) {
+            var display = el.classList.contains('display');
+            var tex = el.textContent.slice(display ? 2 : 1, display ? -2 : -1);
+            window.katex.render(tex, el, { displayMode: display, throwOnError: false });
+        });
+    }
+
+    window.mdpreviewRenderMath = renderMath;
+    renderMath(document.getElementById('preview'));
+})()
@@ -1,12 +1,266 @@
 (function () {
-    var url = 'ws://' + window.location.host + window.location.pathname + 'ws';
+    // The WebSocket is at the server root, given relative to the page as
+    // data-base. The query names the file when previewing a directory.
+    var url = new URL((document.documentElement.dataset.base || '/') + 'ws' + window.location.search, window.location.href);
+    url.protocol = url.protocol === 'https:' ? 'wss:' : 'ws:';
     var preview = document.getElementById("preview");
-    var conn = new WebSocket(url);
+    var banner = document.getElementById("error");
+    var reading = document.getElementById("reading");
+    var bannerText = banner.querySelector('.error-text');
+    var debug = document.documentElement.dataset.debug === 'true';
+    // Decoding is asynchronous, so updates are chained to keep them in order.
+    var queue = Promise.resolve();
+    // Reconnect delays start at minRetry and double up to maxRetry.
+    var minRetry = 500, maxRetry = 10000;
+    var retry = minRetry;
+    var reconnecting = false;
+    // The Mermaid runtime is large, so it is only fetched once a document
+    // has a diagram. It is served with the static files when it was
+    // embedded at build time.
+    var mermaidURL = new URL((document.documentElement.dataset.base || '/') + 'mermaid.min.js', window.location.href).href;
+    var mermaidEmbedded = document.documentElement.dataset.mermaid === 'true';
+    var mermaidLoaded;
+    // The first render is scrolled to the heading named in the URL, which
+    // wasn't on the page when the browser looked for it.
+    var rendered = false;
+    // Task list checkboxes are live when the server accepts saves from us.
+    var save = document.documentElement.dataset.save === 'true';
+    var socket;
+    // With highlight-changes, the top-level blocks of each render that
+    // weren't in the one before flash. Blocks are compared as rendered,
+    // without the source lines that shift whenever lines are added above.
+    var highlightChanges = document.documentElement.dataset.highlightChanges === 'true';
+    var sourceLineAttrs = / data-(?:source|task)-line="\d+"/g;
+    var lastBlocks;
 
-    conn.onclose = function (event) {
-        preview.textContent = 'connection closed';
+    function log() {
+        if (debug) {
+            console.log.apply(console, arguments);
+        }
     }
-    conn.onmessage = function (event) {
-        preview.innerHTML = event.data;
+
+    function showError(text) {
+        bannerText.textContent = text;
+        banner.hidden = false;
+    }
+
+    banner.querySelector('.error-dismiss').addEventListener('click', function () {
+        banner.hidden = true;
+    });
+
+    // Binary messages are a one byte header followed by the payload; header
+    // 1 means the payload is gzipped text.
+    function decode(data) {
+        if (typeof data === 'string') {
+            return Promise.resolve(data);
+        }
+        var bytes = new Uint8Array(data);
+        if (bytes[0] !== 1) {
+            return Promise.reject(new Error('unknown message header ' + bytes[0]));
+        }
+        var stream = new Blob([bytes.subarray(1)]).stream().pipeThrough(new DecompressionStream('gzip'));
+        return new Response(stream).text();
+    }
+
+    function loadMermaid() {
+        if (!mermaidLoaded) {
+            mermaidLoaded = new Promise(function (resolve, reject) {
+                var script = document.createElement('script');
+                script.src = mermaidURL;
+                script.onload = function () {
+                    var root = document.documentElement;
+                    var dark = root.dataset.theme === 'dark' || root.dataset.colorScheme === 'dark';
+                    window.mermaid.initialize({ startOnLoad: false, theme: dark ? 'dark' : 'default' });
+                    resolve(window.mermaid);
+                };
+                script.onerror = function () {
+                    mermaidLoaded = null;
+                    reject(new Error('failed to load Mermaid from ' + mermaidURL));
+                };
+                document.head.appendChild(script);
+            });
+        }
+        return mermaidLoaded;
+    }
+
+    // renderDiagrams draws the Mermaid diagrams of a freshly swapped in
+    // render. Without the runtime they stay as source text.
+    function renderDiagrams() {
+        var nodes = preview.querySelectorAll('.mermaid');
+        if (nodes.length === 0 || !mermaidEmbedded) {
+            return;
+        }
+        return loadMermaid().then(function (mermaid) {
+            return mermaid.run({ nodes: nodes });
+        });
+    }
+
+    // blockKeys returns the comparable text of each top-level block of html.
+    function blockKeys(html) {
+        var template = document.createElement('template');
+        template.innerHTML = html;
+        return Array.prototype.map.call(template.content.children, function (el) {
+            return el.outerHTML.replace(sourceLineAttrs, '');
+        });
     }
-})()
\ No newline at end of file
+
+    // markChanged flags the blocks of the preview, whose keys are blocks,
+    // that have no match among the last render's.
+    function markChanged(blocks) {
+        var seen = {};
+        if (lastBlocks) {
+            lastBlocks.forEach(function (key) {
+                seen[key] = (seen[key] || 0) + 1;
+            });
+        }
+        var children = preview.children;
+        blocks.forEach(function (key, i) {
+            if (seen[key]) {
+                seen[key]--;
+            } else if (lastBlocks && children[i]) {
+                children[i].classList.add('changed');
+            }
+        });
+        lastBlocks = blocks;
+    }
+
+    // showStats draws recent render durations as a sparkline in the corner
+    // of the page, labelled with the latest one.
+    var statsPanel;
+    function showStats(stats) {
+        var svgNS = 'http://www.w3.org/2000/svg';
+        var width = 120, height = 28;
+        if (!statsPanel) {
+            statsPanel = document.createElement('div');
+            statsPanel.className = 'render-stats';
+            statsPanel.title = 'Recent render durations';
+            var svg = document.createElementNS(svgNS, 'svg');
+            svg.setAttribute('width', width);
+            svg.setAttribute('height', height);
+            svg.appendChild(document.createElementNS(svgNS, 'polyline'));
+            statsPanel.appendChild(svg);
+            statsPanel.appendChild(document.createElement('span'));
+            document.body.appendChild(statsPanel);
+        }
+        var history = stats.history;
+        var max = Math.max.apply(null, history) || 1;
+        var step = history.length > 1 ? width / (history.length - 1) : 0;
+        var points = history.map(function (ms, i) {
+            return (i * step).toFixed(1) + ',' + (height - 1 - (ms / max) * (height - 2)).toFixed(1);
+        });
+        statsPanel.querySelector('polyline').setAttribute('points', points.join(' '));
+        statsPanel.querySelector('span').textContent =
+            stats.durationMs.toFixed(1) + ' ms, ' + (stats.bytes / 1024).toFixed(1) + ' KiB';
+    }
+
+    // Toggling a task list checkbox asks the server to rewrite its source
+    // line, if it still reads as shown; the render that follows shows the
+    // result.
+    preview.addEventListener('change', function (event) {
+        var box = event.target;
+        if (!box.matches('input[data-task-line]') || !socket || socket.readyState !== WebSocket.OPEN) {
+            return;
+        }
+        socket.send(JSON.stringify({
+            type: 'toggle',
+            line: parseInt(box.dataset.taskLine, 10),
+            text: box.dataset.taskText,
+            checked: box.checked
+        }));
+    });
+
+    // The editor, when loaded, saves through the connection. It reports
+    // whether msg was sent.
+    window.mdpreviewSend = function (msg) {
+        if (!socket || socket.readyState !== WebSocket.OPEN) {
+            return false;
+        }
+        socket.send(JSON.stringify(msg));
+        return true;
+    };
+
+    function connect() {
+        var conn = new WebSocket(url);
+        socket = conn;
+        conn.binaryType = 'arraybuffer';
+
+        conn.onopen = function () {
+            log('mdpreview: connected to', url);
+            retry = minRetry;
+            if (reconnecting) {
+                // The file may have changed while we were away.
+                conn.send(JSON.stringify({ type: 'resync' }));
+                reconnecting = false;
+                banner.hidden = true;
+            }
+        };
+        conn.onclose = function (event) {
+            log('mdpreview: connection closed', event.code, event.reason);
+            // Keep showing the last render while trying to get back.
+            showError('Connection lost, reconnecting…');
+            reconnecting = true;
+            setTimeout(connect, retry);
+            retry = Math.min(retry * 2, maxRetry);
+        };
+        conn.onmessage = function (event) {
+            queue = queue.then(function () {
+                return decode(event.data);
+            }).then(function (text) {
+                log('mdpreview: received', text.length, 'characters');
+                var msg = JSON.parse(text);
+                switch (msg.type) {
+                case 'render':
+                    // Swapping the content resets the scroll position, so
+                    // put it back to where the reader was.
+                    var x = window.scrollX, y = window.scrollY;
+                    banner.hidden = true;
+                    preview.innerHTML = msg.html;
+                    if (highlightChanges) {
+                        markChanged(blockKeys(msg.html));
+                    }
+                    var target = !rendered && window.location.hash &&
+                        document.getElementById(decodeURIComponent(window.location.hash.slice(1)));
+                    if (target) {
+                        target.scrollIntoView();
+                    } else {
+                        window.scrollTo(x, y);
+                    }
+                    rendered = true;
+                    if (save) {
+                        preview.querySelectorAll('input[data-task-line]').forEach(function (box) {
+                            box.disabled = false;
+                        });
+                    }
+                    if (window.mdpreviewRenderMath) {
+                        window.mdpreviewRenderMath(preview);
+                    }
+                    if (msg.title) {
+                        document.title = msg.title;
+                    }
+                    if (msg.stats) {
+                        showStats(msg.stats);
+                    }
+                    if (msg.reading && reading) {
+                        reading.textContent = msg.reading.words === 0 ? '' :
+                            msg.reading.words.toLocaleString() + ' words, ' + msg.reading.minutes + ' min read';
+                    }
+                    return renderDiagrams();
+                case 'content':
+                    // Kept for an editor that loads after it arrives.
+                    window.mdpreviewSource = msg.content;
+                    if (window.mdpreviewLoadSource) {
+                        window.mdpreviewLoadSource(msg.content);
+                    }
+                    break;
+                case 'error':
+                    showError(msg.error);
+                    break;
+                }
+            }).catch(function (err) {
+                console.error(err);
+            });
+        };
+    }
+
+    connect();
+})()
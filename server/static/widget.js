@@ -0,0 +1,105 @@
+(function () {
+    // Embeddable live preview. Any element carrying a data-mdpreview attribute
+    // is turned into a live preview of the mdpreview server named by
+    // data-mdpreview-server, or the server this script was loaded from.
+    var script = document.currentScript;
+    var defaultServer = script ? new URL(script.src, window.location.href).origin : window.location.origin;
+    var styled = {};
+
+    function logger(enabled) {
+        return function () {
+            if (enabled) {
+                console.log.apply(console, arguments);
+            }
+        };
+    }
+
+    function loadStyles(server) {
+        if (styled[server]) {
+            return;
+        }
+        styled[server] = true;
+        var link = document.createElement('link');
+        link.rel = 'stylesheet';
+        link.href = server + '/github.css';
+        document.head.appendChild(link);
+    }
+
+    function socketURL(server, file) {
+        var url = new URL('/ws', server);
+        url.protocol = url.protocol === 'https:' ? 'wss:' : 'ws:';
+        if (file) {
+            url.searchParams.set('file', file);
+        }
+        return url.toString();
+    }
+
+    // Binary messages are a one byte header followed by the payload; header
+    // 1 means the payload is gzipped text.
+    function decode(data) {
+        if (typeof data === 'string') {
+            return Promise.resolve(data);
+        }
+        var bytes = new Uint8Array(data);
+        if (bytes[0] !== 1) {
+            return Promise.reject(new Error('unknown message header ' + bytes[0]));
+        }
+        var stream = new Blob([bytes.subarray(1)]).stream().pipeThrough(new DecompressionStream('gzip'));
+        return new Response(stream).text();
+    }
+
+    function init(el, opts) {
+        opts = opts || {};
+        var server = (opts.server || el.dataset.mdpreviewServer || defaultServer).replace(/\/+$/, '');
+        var file = opts.file || el.dataset.mdpreviewFile || '';
+        var log = logger(opts.debug || el.dataset.mdpreviewDebug === 'true');
+
+        if (el.dataset.mdpreviewStyles !== 'false') {
+            loadStyles(server);
+        }
+        el.classList.add('markdown-body');
+
+        var url = socketURL(server, file);
+        var conn = new WebSocket(url);
+        conn.binaryType = 'arraybuffer';
+        // Decoding is asynchronous, so updates are chained to keep them in order.
+        var queue = Promise.resolve();
+        conn.onopen = function () {
+            log('mdpreview: connected to', url);
+        };
+        conn.onclose = function (event) {
+            log('mdpreview: connection closed', event.code, event.reason);
+            el.textContent = 'connection closed';
+        };
+        conn.onmessage = function (event) {
+            queue = queue.then(function () {
+                return decode(event.data);
+            }).then(function (text) {
+                log('mdpreview: received', text.length, 'characters');
+                // Other message types are meant for the editor.
+                var msg = JSON.parse(text);
+                if (msg.type === 'render') {
+                    el.innerHTML = msg.html;
+                }
+            }).catch(function (err) {
+                console.error(err);
+            });
+        };
+        return conn;
+    }
+
+    function initAll() {
+        var els = document.querySelectorAll('[data-mdpreview]');
+        for (var i = 0; i < els.length; i++) {
+            init(els[i]);
+        }
+    }
+
+    window.mdpreview = { init: init };
+
+    if (document.readyState === 'loading') {
+        document.addEventListener('DOMContentLoaded', initAll);
+    } else {
+        initAll();
+    }
+})()
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticCaching(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{})
+	ts := startTestServer(t, s)
+	resp, err := http.Get(ts.URL + "/preview.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("GET /preview.js: status %d, ETag %q", resp.StatusCode, etag)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control = %q, want no-cache so upgrades are picked up", got)
+	}
+
+	req, err := http.NewRequest("GET", ts.URL+"/preview.js", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("revalidating an unchanged file: status %d, want 304", resp.StatusCode)
+	}
+}
+
+func TestStaticDevMode(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "preview.js"), []byte("// edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{StaticDir: dir})
+	ts := startTestServer(t, s)
+	resp, err := http.Get(ts.URL + "/preview.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "// edited" {
+		t.Errorf("-dev served %q, not the file on disk", body)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		t.Errorf("-dev file has ETag %q", etag)
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// statsHistory is how many recent render durations are kept for the graph.
+const statsHistory = 60
+
+// renderStats describes a render for the diagnostics graph.
+type renderStats struct {
+	// DurationMS is how long this render took, in milliseconds.
+	DurationMS float64 `json:"durationMs"`
+	// Bytes is the size of the rendered HTML.
+	Bytes int `json:"bytes"`
+	// History lists the durations of recent renders in milliseconds, oldest
+	// first and ending with this one.
+	History []float64 `json:"history"`
+}
+
+// statsRecorder keeps a rolling history of render durations.
+type statsRecorder struct {
+	mu      sync.Mutex
+	history []float64
+}
+
+// milliseconds returns d in milliseconds, to the microsecond.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// record adds a render to the history and returns its stats.
+func (r *statsRecorder) record(took time.Duration, size int) *renderStats {
+	ms := milliseconds(took)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.history = append(r.history, ms)
+	if len(r.history) > statsHistory {
+		r.history = r.history[len(r.history)-statsHistory:]
+	}
+	return &renderStats{
+		DurationMS: ms,
+		Bytes:      size,
+		History:    append([]float64(nil), r.history...),
+	}
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsHistory(t *testing.T) {
+	var r statsRecorder
+	var stats *renderStats
+	for i := 1; i <= statsHistory+5; i++ {
+		stats = r.record(time.Duration(i)*time.Millisecond, i)
+	}
+	if len(stats.History) != statsHistory {
+		t.Fatalf("history holds %d renders, want the last %d", len(stats.History), statsHistory)
+	}
+	if first, last := stats.History[0], stats.History[statsHistory-1]; first != 6 || last != statsHistory+5 {
+		t.Errorf("history runs %v to %v, want 6 to %d", first, last, statsHistory+5)
+	}
+	// The returned history is a copy.
+	stats.History[0] = -1
+	if r.history[0] == -1 {
+		t.Error("recorder shares its history with a render's stats")
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// taskItem matches a task list item, in a blockquote or not, capturing the
+// text up to its box and the box's mark.
+var taskItem = regexp.MustCompile(`^((?:[ \t]*>)*[ \t]*(?:[-*+]|[0-9]{1,9}[.)])[ \t]+\[)([ xX])\]`)
+
+// taskLines returns the 1-based source lines of the task list items in
+// Markdown, in document order, leaving out fenced code blocks.
+func taskLines(lines []string) []int {
+	var tasks []int
+	for i := 0; i < len(lines); i++ {
+		if fenceOpen.MatchString(lines[i]) {
+			i = skipFence(lines, i) - 1
+			continue
+		}
+		if taskItem.MatchString(lines[i]) {
+			tasks = append(tasks, i+1)
+		}
+	}
+	return tasks
+}
+
+// linkTasks returns a transform tying the checkboxes of task list items, in
+// order, to tasks, their 1-based lines in src, with data-task-line and
+// data-task-text attributes giving the line's number and text. The preview
+// sends both to toggle them. If the render doesn't have exactly one checkbox
+// per line, as when raw HTML adds some, none are tied, rather than risk
+// toggling the wrong item.
+func linkTasks(src []string, tasks []int) transform {
+	return func(root *html.Node) {
+		var boxes []*html.Node
+		walk(root, func(n *html.Node) bool {
+			if typ, _ := attr(n, "type"); n.Type == html.ElementNode && n.DataAtom == atom.Input && typ == "checkbox" {
+				boxes = append(boxes, n)
+			}
+			return true
+		})
+		if len(boxes) != len(tasks) {
+			return
+		}
+		for i, box := range boxes {
+			setAttr(box, "data-task-line", strconv.Itoa(tasks[i]))
+			setAttr(box, "data-task-text", src[tasks[i]-1])
+		}
+	}
+}
+
+// toggleTask checks or unchecks the task list item on the 1-based line of
+// Markdown input, keeping the rest of the file byte for byte. The line must
+// still hold the item text, the line as the preview showed it, checked or
+// not; otherwise the file changed since, and the item may have moved.
+func (s *Server) toggleTask(input []byte, line int, text string, checked bool) ([]byte, error) {
+	body, _, _ := s.frontMatter(input)
+	isTask := false
+	for _, l := range taskLines(sourceLines(body)) {
+		isTask = isTask || l == line
+	}
+	if !isTask {
+		return nil, fmt.Errorf("line %d is not a task list item", line)
+	}
+	lines := strings.SplitAfter(string(input), "\n")
+	if !sameTask(lines[line-1], text) {
+		return nil, fmt.Errorf("line %d changed since the preview showed it", line)
+	}
+	loc := taskItem.FindStringSubmatchIndex(lines[line-1])
+	mark := " "
+	if checked {
+		mark = "x"
+	}
+	lines[line-1] = lines[line-1][:loc[4]] + mark + lines[line-1][loc[5]:]
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// sameTask reports whether source lines a and b hold the same task list
+// item, checked or not.
+func sameTask(a, b string) bool {
+	unmark := func(line string) string {
+		return taskItem.ReplaceAllString(strings.TrimRight(line, "\r\n"), "$1 ]")
+	}
+	return unmark(a) == unmark(b)
+}
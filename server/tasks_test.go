@@ -0,0 +1,70 @@
+package server
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestToggleTask(t *testing.T) {
+	s := newTestServer(t, "doc.md", "", Options{})
+	const src = "# Tasks\n\n- [ ] one\n- [x] two\n"
+	tests := []struct {
+		line    int
+		text    string
+		checked bool
+		want    string
+	}{
+		{3, "- [ ] one", true, "# Tasks\n\n- [x] one\n- [x] two\n"},
+		{4, "- [x] two", false, "# Tasks\n\n- [ ] one\n- [ ] two\n"},
+		// The mark may have changed since, as by another toggle.
+		{4, "- [ ] two", true, "# Tasks\n\n- [ ] one\n- [x] two\n"},
+		{3, "- [ ] two", true, ""},
+		{1, "# Tasks", true, ""},
+	}
+	for _, tt := range tests {
+		got, err := s.toggleTask([]byte(src), tt.line, tt.text, tt.checked)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("toggle line %d reading %q: no error", tt.line, tt.text)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("toggle line %d reading %q: %v", tt.line, tt.text, err)
+		} else if string(got) != tt.want {
+			t.Errorf("toggle line %d reading %q = %q, want %q", tt.line, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestToggleAfterEdit(t *testing.T) {
+	s := newTestServer(t, "doc.md", "- [ ] one\n- [ ] two\n", Options{AllowSave: true})
+	ws := dialTestServer(t, startTestServer(t, s), "")
+	if msg := readConnected(t, ws); !strings.Contains(msg.HTML, `data-task-line="1" data-task-text="- [ ] one"`) {
+		t.Fatalf("render doesn't tie the checkbox to its line:\n%s", msg.HTML)
+	}
+
+	// A line inserted above moves the item the preview showed on line 1.
+	const edited = "- [ ] new\n- [ ] one\n- [ ] two\n"
+	if err := os.WriteFile(s.path, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteJSON(wsMessage{Type: "toggle", Line: 1, Text: "- [ ] one", Checked: true}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readType(t, ws, "error"); !strings.Contains(msg.Error, "changed") {
+		t.Errorf("stale toggle answered %q", msg.Error)
+	}
+	if content, _ := os.ReadFile(s.path); string(content) != edited {
+		t.Errorf("stale toggle wrote %q", content)
+	}
+
+	if err := ws.WriteJSON(wsMessage{Type: "toggle", Line: 2, Text: "- [ ] one", Checked: true}); err != nil {
+		t.Fatal(err)
+	}
+	waitForRender(t, ws, `checked="" disabled="" data-task-line="2"`)
+	if content, _ := os.ReadFile(s.path); string(content) != "- [ ] new\n- [x] one\n- [ ] two\n" {
+		t.Errorf("toggle wrote %q", content)
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Themes accepted by Options.Theme.
+const (
+	ThemeAuto  = "auto"
+	ThemeLight = "light"
+	ThemeDark  = "dark"
+)
+
+func validTheme(theme string) error {
+	switch theme {
+	case ThemeAuto, ThemeLight, ThemeDark:
+		return nil
+	}
+	return fmt.Errorf("unknown theme %q, expected %s, %s or %s", theme, ThemeAuto, ThemeLight, ThemeDark)
+}
+
+// resolveTheme turns ThemeAuto into a concrete theme for output that has no
+// browser to follow prefers-color-scheme, asking detect for the operating
+// system's appearance. Light is used when the appearance is unknown.
+func resolveTheme(theme string, detect func() string) string {
+	if theme != ThemeAuto {
+		return theme
+	}
+	if detect() == ThemeDark {
+		return ThemeDark
+	}
+	return ThemeLight
+}
+
+// osAppearance reports whether the operating system is set to a light or
+// dark appearance, or "" when that can't be determined.
+func osAppearance() string {
+	switch runtime.GOOS {
+	case "darwin":
+		// The key only exists while dark mode is on.
+		out, err := exec.Command("defaults", "read", "-g", "AppleInterfaceStyle").Output()
+		if err != nil {
+			return ThemeLight
+		}
+		return parseMacAppearance(string(out))
+	case "windows":
+		out, err := exec.Command("reg", "query",
+			`HKCU\Software\Microsoft\Windows\CurrentVersion\Themes\Personalize`,
+			"/v", "AppsUseLightTheme").Output()
+		if err != nil {
+			return ""
+		}
+		return parseWindowsAppearance(string(out))
+	default:
+		out, err := exec.Command("gsettings", "get", "org.gnome.desktop.interface", "color-scheme").Output()
+		if err == nil {
+			if theme := parseGnomeColorScheme(string(out)); theme != "" {
+				return theme
+			}
+		}
+		out, err = exec.Command("gsettings", "get", "org.gnome.desktop.interface", "gtk-theme").Output()
+		if err != nil {
+			return ""
+		}
+		return parseGtkTheme(string(out))
+	}
+}
+
+func parseMacAppearance(out string) string {
+	if strings.EqualFold(strings.TrimSpace(out), "dark") {
+		return ThemeDark
+	}
+	return ThemeLight
+}
+
+func parseWindowsAppearance(out string) string {
+	fields := strings.Fields(out)
+	for i, f := range fields {
+		if f == "REG_DWORD" && i+1 < len(fields) {
+			if fields[i+1] == "0x0" {
+				return ThemeDark
+			}
+			return ThemeLight
+		}
+	}
+	return ""
+}
+
+func parseGnomeColorScheme(out string) string {
+	switch strings.Trim(strings.TrimSpace(out), "'") {
+	case "prefer-dark":
+		return ThemeDark
+	case "prefer-light":
+		return ThemeLight
+	}
+	// "default" leaves the choice to the GTK theme.
+	return ""
+}
+
+func parseGtkTheme(out string) string {
+	name := strings.Trim(strings.TrimSpace(out), "'")
+	if name == "" {
+		return ""
+	}
+	if strings.Contains(strings.ToLower(name), "dark") {
+		return ThemeDark
+	}
+	return ThemeLight
+}
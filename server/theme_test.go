@@ -0,0 +1,68 @@
+package server
+
+import "testing"
+
+func TestResolveTheme(t *testing.T) {
+	tests := []struct {
+		theme, os, want string
+	}{
+		{ThemeAuto, ThemeLight, ThemeLight},
+		{ThemeAuto, ThemeDark, ThemeDark},
+		{ThemeAuto, "", ThemeLight},
+		{ThemeLight, ThemeDark, ThemeLight},
+		{ThemeDark, ThemeLight, ThemeDark},
+	}
+	for _, tt := range tests {
+		asked := false
+		detect := func() string {
+			asked = true
+			return tt.os
+		}
+		if got := resolveTheme(tt.theme, detect); got != tt.want {
+			t.Errorf("resolveTheme(%q) with OS %q = %q, want %q", tt.theme, tt.os, got, tt.want)
+		}
+		if asked != (tt.theme == ThemeAuto) {
+			t.Errorf("resolveTheme(%q) asked the OS: %v", tt.theme, asked)
+		}
+	}
+}
+
+func TestParseAppearance(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func(string) string
+		out   string
+		want  string
+	}{
+		{"mac dark", parseMacAppearance, "Dark\n", ThemeDark},
+		{"mac light", parseMacAppearance, "", ThemeLight},
+		{"windows dark", parseWindowsAppearance, "\r\nHKEY_CURRENT_USER\\...\\Personalize\r\n    AppsUseLightTheme    REG_DWORD    0x0\r\n", ThemeDark},
+		{"windows light", parseWindowsAppearance, "    AppsUseLightTheme    REG_DWORD    0x1\r\n", ThemeLight},
+		{"windows unknown", parseWindowsAppearance, "ERROR: not found", ""},
+		{"gnome dark", parseGnomeColorScheme, "'prefer-dark'\n", ThemeDark},
+		{"gnome light", parseGnomeColorScheme, "'prefer-light'\n", ThemeLight},
+		{"gnome default", parseGnomeColorScheme, "'default'\n", ""},
+		{"gtk dark", parseGtkTheme, "'Adwaita-dark'\n", ThemeDark},
+		{"gtk light", parseGtkTheme, "'Adwaita'\n", ThemeLight},
+		{"gtk unknown", parseGtkTheme, "''\n", ""},
+	}
+	for _, tt := range tests {
+		if got := tt.parse(tt.out); got != tt.want {
+			t.Errorf("%s: parsed %q as %q, want %q", tt.name, tt.out, got, tt.want)
+		}
+	}
+}
+
+func TestStaticPageTheme(t *testing.T) {
+	s := newTestServer(t, "doc.md", "# Doc\n", Options{Theme: ThemeDark})
+	if got := s.indexData(s.path, true)["theme"]; got != ThemeDark {
+		t.Errorf("static page theme = %v, want %s", got, ThemeDark)
+	}
+	s = newTestServer(t, "doc.md", "# Doc\n", Options{})
+	if got := s.indexData(s.path, false)["theme"]; got != ThemeAuto {
+		t.Errorf("live page theme = %v, want %s for the browser to resolve", got, ThemeAuto)
+	}
+	if got := s.indexData(s.path, true)["theme"]; got != ThemeLight && got != ThemeDark {
+		t.Errorf("static page theme = %v, want auto resolved", got)
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatterTitle returns the title field of front matter, or "" if it has
+// none.
+func frontMatterTitle(front []byte) string {
+	mapping := frontMatterMapping(front)
+	if mapping == nil {
+		return ""
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if value := mapping.Content[i+1]; mapping.Content[i].Value == "title" && value.Kind == yaml.ScalarNode {
+			return strings.TrimSpace(value.Value)
+		}
+	}
+	return ""
+}
+
+// firstHeading returns a transform that sets *title to the text of the
+// render's first h1, if it has one.
+func firstHeading(title *string) transform {
+	return func(root *html.Node) {
+		walk(root, func(n *html.Node) bool {
+			if *title != "" {
+				return false
+			}
+			if n.Type == html.ElementNode && n.DataAtom == atom.H1 {
+				*title = strings.TrimSpace(textContent(n))
+				return false
+			}
+			return true
+		})
+	}
+}
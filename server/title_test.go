@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFrontMatterTitle(t *testing.T) {
+	for front, want := range map[string]string{
+		"title: Release notes\ndate: 2024-01-02\n": "Release notes",
+		"date: 2024-01-02\ntitle: '  Padded  '\n":  "Padded",
+		"tags: [a, b]\n":            "",
+		"title: [not, a, string]\n": "",
+		"- just\n- a list\n":        "",
+		"":                          "",
+	} {
+		if got := frontMatterTitle([]byte(front)); got != want {
+			t.Errorf("frontMatterTitle(%q) = %q, want %q", front, got, want)
+		}
+	}
+}
+
+func TestPageTitle(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		opts Options
+		want string
+	}{
+		{"front matter", "---\ntitle: From YAML\n---\n\n# Heading\n", Options{}, "From YAML"},
+		{"cover", "---\ntitle: On the cover\nauthor: Ann\n---\n\n# Heading\n", Options{Cover: true}, "On the cover"},
+		{"heading", "Intro\n\n# First *heading*\n\n# Second\n", Options{}, "First heading"},
+		{"untitled front matter", "---\ndate: 2024-01-02\n---\n\n# Heading\n", Options{}, "Heading"},
+		{"front matter off", "---\ntitle: Ignored\n---\n\n# Heading\n", Options{NoFrontMatter: true}, "Heading"},
+		{"file name", "Just text.\n", Options{}, "doc.md"},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, "doc.md", tt.src, tt.opts)
+		rendered, err := s.Render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page bytes.Buffer
+		if err := s.WritePage(&page, rendered); err != nil {
+			t.Fatal(err)
+		}
+		if want := "<title>" + tt.want + "</title>"; !strings.Contains(page.String(), want) {
+			t.Errorf("%s: page lacks %s", tt.name, want)
+		}
+	}
+}
+
+func TestTitleOnFileSwitch(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.md": "---\ntitle: Alpha\n---\n\n# Heading of a\n",
+		"b.md": "# Beta\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newTestServerFor(t, []string{dir}, Options{})
+	ts := startTestServer(t, s)
+
+	if msg := readConnected(t, dialTestServer(t, ts, "?file=a.md")); msg.Title != "Alpha" {
+		t.Errorf("title of a.md = %q, want Alpha", msg.Title)
+	}
+	b := dialTestServer(t, ts, "?file=b.md")
+	if msg := readConnected(t, b); msg.Title != "Beta" {
+		t.Errorf("title after switching to b.md = %q, want Beta", msg.Title)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.md"), []byte("# Beta, renamed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if msg := waitForRender(t, b, "renamed"); msg.Title != "Beta, renamed" {
+		t.Errorf("title after editing b.md = %q", msg.Title)
+	}
+
+	resp, err := http.Get(ts.URL + "/print?file=a.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "<title>Alpha</title>") {
+		t.Errorf("print page of a.md isn't titled Alpha:\n%.300s", page)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"gopkg.in/yaml.v3"
+)
+
+// codeValidators parse the content of code blocks by language.
+var codeValidators = map[string]func([]byte) error{
+	"json": validateJSON,
+	"yaml": validateYAML,
+	"yml":  validateYAML,
+	"toml": validateTOML,
+}
+
+// languageNames are the display names of validated languages.
+var languageNames = map[string]string{
+	"json": "JSON",
+	"yaml": "YAML",
+	"yml":  "YAML",
+	"toml": "TOML",
+}
+
+// validateCode marks JSON, YAML and TOML code blocks that fail to parse with
+// a warning placed before the block. The code itself is left untouched.
+func validateCode(root *html.Node) {
+	walk(root, func(n *html.Node) bool {
+		lang, pre, ok := codeBlock(n)
+		if !ok {
+			return true
+		}
+		validate := codeValidators[lang]
+		if validate == nil {
+			return false
+		}
+		if err := validate([]byte(textContent(pre))); err != nil {
+			n.Parent.InsertBefore(warningNode("code-warning", fmt.Sprintf("Invalid %s: %v", languageNames[lang], err)), n)
+		}
+		return false
+	})
+}
+
+// warningNode is a note of the given class to insert into a render.
+func warningNode(class, msg string) *html.Node {
+	warning := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "p",
+		DataAtom: atom.P,
+		Attr: []html.Attribute{
+			{Key: "class", Val: class},
+			{Key: "role", Val: "note"},
+		},
+	}
+	warning.AppendChild(&html.Node{Type: html.TextNode, Data: msg})
+	return warning
+}
+
+func validateJSON(data []byte) error {
+	var v interface{}
+	return json.Unmarshal(data, &v)
+}
+
+// validateYAML accepts a stream of several documents.
+func validateYAML(data []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func validateTOML(data []byte) error {
+	var v interface{}
+	_, err := toml.Decode(string(data), &v)
+	return err
+}
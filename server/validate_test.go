@@ -0,0 +1,62 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateCode(t *testing.T) {
+	tests := []struct {
+		lang, code string
+		valid      bool
+	}{
+		{"json", `{"name": "mdpreview", "tags": [1, 2]}`, true},
+		{"json", `{"name": "mdpreview",}`, false},
+		{"yaml", "name: mdpreview\ntags:\n  - a\n---\nsecond: doc", true},
+		{"yml", "name: [unclosed", false},
+		{"toml", "name = \"mdpreview\"\n[server]\nport = 8080", true},
+		{"toml", "name = ", false},
+		// Other languages aren't checked.
+		{"go", "func {", true},
+	}
+	for _, engine := range []string{EngineGFM, EngineGoldmark} {
+		for _, tt := range tests {
+			src := "# Config\n\n```" + tt.lang + "\n" + tt.code + "\n```\n"
+			s := newTestServer(t, "doc.md", src, Options{Engine: engine, ValidateCode: true})
+			rendered, err := s.Render()
+			if err != nil {
+				t.Fatal(err)
+			}
+			warned := strings.Contains(string(rendered), `class="code-warning"`)
+			if warned == tt.valid {
+				t.Errorf("%s: %s block %q: warning %v, want %v:\n%s", engine, tt.lang, tt.code, warned, !tt.valid, rendered)
+			}
+			// The block itself is still shown, highlighted.
+			if !strings.Contains(string(rendered), "<pre") {
+				t.Errorf("%s: %s block dropped:\n%s", engine, tt.lang, rendered)
+			}
+		}
+	}
+}
+
+func TestValidateCodeOff(t *testing.T) {
+	s := newTestServer(t, "doc.md", "```json\n{,}\n```\n", Options{})
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(rendered), "code-warning") {
+		t.Errorf("invalid JSON flagged without -validate-code:\n%s", rendered)
+	}
+}
+
+func TestValidateCodeMessage(t *testing.T) {
+	s := newTestServer(t, "doc.md", "```json\n{\"a\": }\n```\n", Options{ValidateCode: true})
+	rendered, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rendered), "Invalid JSON: invalid character") {
+		t.Errorf("warning doesn't give the parse error:\n%s", rendered)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Reading speeds for estimating reading time: words per minute for text
+// delimited by spaces, and characters per minute for Chinese and Japanese,
+// which aren't.
+const (
+	wordsPerMinute = 200
+	cjkPerMinute   = 500
+)
+
+// readingStats is the length of a document.
+type readingStats struct {
+	Words   int `json:"words"`
+	Minutes int `json:"minutes"`
+}
+
+var (
+	// htmlTag matches HTML tags and comments in Markdown.
+	htmlTag = regexp.MustCompile(`(?s)<!--.*?-->|<[^>]+>`)
+	// linkTarget matches the target of a Markdown link or image, which
+	// isn't read.
+	linkTarget = regexp.MustCompile(`\]\([^)]*\)`)
+)
+
+// countWords measures Markdown source, leaving out fenced code blocks, HTML
+// tags and link targets. Each Chinese or Japanese character counts as a
+// word; other words are runs of letters and digits.
+func countWords(src []byte) readingStats {
+	lines := strings.Split(string(src), "\n")
+	var text strings.Builder
+	for i := 0; i < len(lines); i++ {
+		if fenceOpen.MatchString(lines[i]) {
+			i = skipFence(lines, i) - 1
+			continue
+		}
+		text.WriteString(lines[i])
+		text.WriteByte('\n')
+	}
+	prose := linkTarget.ReplaceAllString(htmlTag.ReplaceAllString(text.String(), " "), "]")
+
+	words, cjk := 0, 0
+	inWord := false
+	for _, r := range prose {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana):
+			cjk++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			if !inWord {
+				words++
+			}
+			inWord = true
+		case r == '\'' || r == '’':
+			// Apostrophes don't split words such as don't.
+		default:
+			inWord = false
+		}
+	}
+
+	minutes := 0
+	if words+cjk > 0 {
+		// Round up, so that anything at all takes a minute.
+		minutes = (words*cjkPerMinute + cjk*wordsPerMinute + wordsPerMinute*cjkPerMinute - 1) / (wordsPerMinute * cjkPerMinute)
+	}
+	return readingStats{Words: words + cjk, Minutes: minutes}
+}
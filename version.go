@@ -0,0 +1,34 @@
+package main
+
+// runtime/debug is renamed so as not to clash with the -debug flag.
+import buildinfo "runtime/debug"
+
+// version and commit identify the build. Release builds set them with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=abc1234"
+//
+// as the Makefile does; otherwise they come from the module and VCS
+// information Go stamps into the binary, when there is any.
+var (
+	version = ""
+	commit  = ""
+)
+
+// buildVersion returns the version and commit of the running binary.
+func buildVersion() (string, string) {
+	v, c := version, commit
+	if info, ok := buildinfo.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			if c == "" && setting.Key == "vcs.revision" {
+				c = setting.Value
+			}
+		}
+	}
+	if v == "" {
+		v = "dev"
+	}
+	return v, c
+}